@@ -0,0 +1,115 @@
+package main
+
+import "sync"
+
+// arbitratorWindowSize is the size of the sliding sequence-number window kept
+// per StreamID for A/B dedup. 4096 slots comfortably covers the reorder depth
+// seen between Source1 (primary) and Source2 (delayed) feeds in practice.
+const arbitratorWindowSize = 4096
+
+// arbitratorSlot remembers whether a given sequence number has already been
+// forwarded downstream.
+type arbitratorSlot struct {
+	seq   uint32
+	valid bool
+}
+
+// Arbitrator performs A/B arbitration and sequence-based dedup for a single
+// StreamID: whichever source delivers a SequenceNo first wins and is
+// forwarded downstream, the other copy is dropped as a duplicate.
+type Arbitrator struct {
+	mu          sync.Mutex
+	streamID    int
+	slots       [arbitratorWindowSize]arbitratorSlot
+	initialized bool
+	highSeq     uint32
+	mergedSeq   uint64
+	DedupCount  uint64
+	LateDrops   uint64
+
+	// onLateDrop, if set, is invoked (without a.mu held) whenever a packet
+	// is dropped as unrecoverably late, i.e. a gap on this stream that will
+	// never be filled. Used to mark dependent order books Stale.
+	onLateDrop func()
+}
+
+// SetOnLateDrop registers fn to run after every late drop on this
+// arbitrator's stream. It is a no-op once a callback is already set, since
+// every caller for a given StreamID registers the same handler.
+func (a *Arbitrator) SetOnLateDrop(fn func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.onLateDrop == nil {
+		a.onLateDrop = fn
+	}
+}
+
+var (
+	arbitrators      = make(map[int]*Arbitrator)
+	arbitratorsMutex sync.Mutex
+)
+
+// getArbitrator returns the Arbitrator for streamID, creating it on first use.
+func getArbitrator(streamID int) *Arbitrator {
+	arbitratorsMutex.Lock()
+	defer arbitratorsMutex.Unlock()
+
+	a := arbitrators[streamID]
+	if a == nil {
+		a = &Arbitrator{streamID: streamID}
+		arbitrators[streamID] = a
+	}
+	return a
+}
+
+// lowWaterMark returns the oldest sequence number still inside the window.
+// Callers must hold a.mu.
+func (a *Arbitrator) lowWaterMark() uint32 {
+	if a.highSeq < arbitratorWindowSize {
+		return 0
+	}
+	return a.highSeq - arbitratorWindowSize + 1
+}
+
+// Arbitrate decides whether a packet carrying SequenceNo seq should be
+// forwarded downstream. It returns forward=true exactly once per sequence
+// number (whichever source calls first wins); later calls with the same seq
+// report a duplicate. Calls for a seq that has already slid out of the
+// window's low-water mark report a late drop instead.
+func (a *Arbitrator) Arbitrate(seq uint32) (forward, lateDrop bool) {
+	a.mu.Lock()
+
+	if a.initialized && seq < a.lowWaterMark() {
+		a.LateDrops++
+		onLateDrop := a.onLateDrop
+		a.mu.Unlock()
+		if onLateDrop != nil {
+			onLateDrop()
+		}
+		return false, true
+	}
+
+	slot := &a.slots[seq%arbitratorWindowSize]
+	if slot.valid && slot.seq == seq {
+		a.DedupCount++
+		a.mu.Unlock()
+		return false, false
+	}
+
+	slot.seq = seq
+	slot.valid = true
+	if !a.initialized || seq > a.highSeq {
+		a.highSeq = seq
+		a.initialized = true
+	}
+	a.mergedSeq++
+	a.mu.Unlock()
+	return true, false
+}
+
+// Stats returns a snapshot of the arbitrator's dedup counters.
+func (a *Arbitrator) Stats() (dedup, lateDrops, merged uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.DedupCount, a.LateDrops, a.mergedSeq
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestArbitrateForwardsFirstSeenSequence(t *testing.T) {
+	a := &Arbitrator{}
+	forward, lateDrop := a.Arbitrate(10)
+	if !forward || lateDrop {
+		t.Fatalf("expected first sighting of seq 10 to forward, got forward=%v lateDrop=%v", forward, lateDrop)
+	}
+}
+
+func TestArbitrateDropsDuplicateSequence(t *testing.T) {
+	a := &Arbitrator{}
+	a.Arbitrate(10)
+
+	forward, lateDrop := a.Arbitrate(10)
+	if forward || lateDrop {
+		t.Fatalf("expected second sighting of seq 10 to dedup, got forward=%v lateDrop=%v", forward, lateDrop)
+	}
+
+	dedup, lateDrops, merged := a.Stats()
+	if dedup != 1 || lateDrops != 0 || merged != 1 {
+		t.Fatalf("unexpected stats after one duplicate: dedup=%d lateDrops=%d merged=%d", dedup, lateDrops, merged)
+	}
+}
+
+func TestArbitrateAcceptsLowWaterMarkBoundary(t *testing.T) {
+	a := &Arbitrator{}
+	a.Arbitrate(arbitratorWindowSize) // highSeq = windowSize, lowWaterMark = highSeq-window+1 = 1
+
+	forward, lateDrop := a.Arbitrate(1) // exactly at the low-water mark: still in window
+	if !forward || lateDrop {
+		t.Fatalf("expected seq at the low-water mark to forward, got forward=%v lateDrop=%v", forward, lateDrop)
+	}
+}
+
+func TestArbitrateLateDropsPastLowWaterMark(t *testing.T) {
+	a := &Arbitrator{}
+	a.Arbitrate(arbitratorWindowSize) // lowWaterMark = 1
+
+	forward, lateDrop := a.Arbitrate(0) // one below the low-water mark
+	if forward || !lateDrop {
+		t.Fatalf("expected seq below the low-water mark to late-drop, got forward=%v lateDrop=%v", forward, lateDrop)
+	}
+
+	_, lateDrops, _ := a.Stats()
+	if lateDrops != 1 {
+		t.Fatalf("expected LateDrops=1, got %d", lateDrops)
+	}
+}
+
+func TestArbitrateInvokesOnLateDropCallback(t *testing.T) {
+	a := &Arbitrator{}
+	called := false
+	a.SetOnLateDrop(func() { called = true })
+
+	a.Arbitrate(arbitratorWindowSize)
+	a.Arbitrate(0)
+
+	if !called {
+		t.Fatal("expected onLateDrop to be invoked on a late drop")
+	}
+}
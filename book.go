@@ -0,0 +1,271 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Level is one price level of resolved depth.
+type Level struct {
+	Price    int32
+	Quantity int32
+}
+
+// restingOrder is what a Book remembers about a live order so it can apply
+// modify/cancel/trade messages, which only carry the order's ID.
+type restingOrder struct {
+	Side     byte // 'B' or 'S'
+	Price    int32
+	Quantity int32
+}
+
+// Book reconstructs L2 depth for a single Token from the N/M/X/T/C message
+// stream. It is driven exclusively by applyXxx calls from the book builder
+// subscription in pipeline.go; callers read depth via TopN.
+type Book struct {
+	mu sync.Mutex
+
+	token  int32
+	orders map[float64]restingOrder
+
+	bidLevels map[int32]int32 // price -> aggregate resting quantity
+	askLevels map[int32]int32
+
+	// stale is set when the arbitrator reports an unrecovered gap on the
+	// stream this book was last updated from, and cleared only by Resync.
+	stale    bool
+	streamID int
+
+	// onChange, if set, is invoked after every mutation with a fresh
+	// snapshot so downstream analytics get L2 depth without re-parsing.
+	onChange func(token int32, bids, asks []Level)
+}
+
+func newBook(token int32) *Book {
+	return &Book{
+		token:     token,
+		orders:    make(map[float64]restingOrder),
+		bidLevels: make(map[int32]int32),
+		askLevels: make(map[int32]int32),
+	}
+}
+
+var (
+	books      = make(map[int32]*Book)
+	booksMutex sync.Mutex
+)
+
+// getBook returns the Book for token, creating it on first use.
+func getBook(token int32) *Book {
+	booksMutex.Lock()
+	defer booksMutex.Unlock()
+
+	b := books[token]
+	if b == nil {
+		b = newBook(token)
+		books[token] = b
+	}
+	return b
+}
+
+// MarkStreamStale marks every book last updated from streamID as Stale,
+// used when the arbitrator reports an unrecovered gap on that stream: those
+// books' depth can no longer be trusted until a full snapshot arrives.
+func MarkStreamStale(streamID int) {
+	booksMutex.Lock()
+	defer booksMutex.Unlock()
+
+	for _, b := range books {
+		b.mu.Lock()
+		if b.streamID == streamID {
+			b.stale = true
+		}
+		b.mu.Unlock()
+	}
+}
+
+func levelSide(levels map[int32]int32, price, delta int32) {
+	qty := levels[price] + delta
+	if qty <= 0 {
+		delete(levels, price)
+		return
+	}
+	levels[price] = qty
+}
+
+// applyOrder handles 'N' (add), 'M' (modify, treated as cancel+add per NSE
+// semantics), and 'X' (cancel).
+func (b *Book) applyOrder(streamID int, m *OrderMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streamID = streamID
+
+	switch m.MessageType {
+	case 'N':
+		b.orders[m.OrderID] = restingOrder{Side: m.OrderType, Price: m.Price, Quantity: m.Quantity}
+		b.adjustLevel(m.OrderType, m.Price, m.Quantity)
+
+	case 'M':
+		if existing, ok := b.orders[m.OrderID]; ok {
+			b.adjustLevel(existing.Side, existing.Price, -existing.Quantity)
+		}
+		b.orders[m.OrderID] = restingOrder{Side: m.OrderType, Price: m.Price, Quantity: m.Quantity}
+		b.adjustLevel(m.OrderType, m.Price, m.Quantity)
+
+	case 'X':
+		if existing, ok := b.orders[m.OrderID]; ok {
+			b.adjustLevel(existing.Side, existing.Price, -existing.Quantity)
+			delete(b.orders, m.OrderID)
+		}
+	}
+
+	b.notifyLocked()
+}
+
+// applyTrade decrements resting quantity on both matched legs of a trade.
+func (b *Book) applyTrade(streamID int, m *TradeMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streamID = streamID
+
+	b.fillLocked(m.BuyOrderID, m.TradeQty)
+	b.fillLocked(m.SellOrderID, m.TradeQty)
+
+	b.notifyLocked()
+}
+
+// applyTradeCancel restores quantity removed by a previously applied trade.
+func (b *Book) applyTradeCancel(streamID int, m *TradeCancelMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streamID = streamID
+
+	b.restoreLocked(m.BuyOrderID, m.TradeQty)
+	b.restoreLocked(m.SellOrderID, m.TradeQty)
+
+	b.notifyLocked()
+}
+
+// fillLocked reduces the resting quantity for orderID by qty. The order is
+// kept in b.orders even once exhausted (at Quantity 0) rather than deleted,
+// since a TradeCancel for that same trade can arrive later and needs the
+// order's side/price to restore the level; only an explicit 'X' cancel
+// removes an order from b.orders. Callers must hold b.mu.
+func (b *Book) fillLocked(orderID float64, qty int32) {
+	existing, ok := b.orders[orderID]
+	if !ok {
+		return
+	}
+	b.adjustLevel(existing.Side, existing.Price, -qty)
+	existing.Quantity -= qty
+	b.orders[orderID] = existing
+}
+
+// restoreLocked adds qty back to orderID's resting quantity (trade cancel).
+// Callers must hold b.mu.
+func (b *Book) restoreLocked(orderID float64, qty int32) {
+	existing, ok := b.orders[orderID]
+	if !ok {
+		return
+	}
+	existing.Quantity += qty
+	b.orders[orderID] = existing
+	b.adjustLevel(existing.Side, existing.Price, qty)
+}
+
+// adjustLevel must be called with b.mu held.
+func (b *Book) adjustLevel(side byte, price, delta int32) {
+	if side == 'B' {
+		levelSide(b.bidLevels, price, delta)
+	} else {
+		levelSide(b.askLevels, price, delta)
+	}
+}
+
+func (b *Book) notifyLocked() {
+	if b.onChange == nil {
+		return
+	}
+	bids, asks := b.topNLocked(len(b.bidLevels) + len(b.askLevels) + 1)
+	b.onChange(b.token, bids, asks)
+}
+
+// TopN returns up to depth price levels per side: bids highest-price-first,
+// asks lowest-price-first. ok is false when the book is Stale, in which case
+// bids/asks are still the last-known depth but must not be treated as
+// trusted quotes until a Resync clears the stale flag.
+func (b *Book) TopN(depth int) (bids, asks []Level, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bids, asks = b.topNLocked(depth)
+	return bids, asks, !b.stale
+}
+
+func (b *Book) topNLocked(depth int) (bids, asks []Level) {
+	bids = sortedLevels(b.bidLevels, true, depth)
+	asks = sortedLevels(b.askLevels, false, depth)
+	return bids, asks
+}
+
+// Stale reports whether this book needs a full resync before its depth can
+// be trusted (set after an unrecovered sequence gap on its stream).
+func (b *Book) Stale() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stale
+}
+
+// Resync replaces the book's state with a full snapshot (e.g. from a pcap
+// replay or a slower snapshot channel) and clears Stale.
+func (b *Book) Resync(bids, asks []Level) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.orders = make(map[float64]restingOrder)
+	b.bidLevels = make(map[int32]int32)
+	b.askLevels = make(map[int32]int32)
+	for _, l := range bids {
+		b.bidLevels[l.Price] = l.Quantity
+	}
+	for _, l := range asks {
+		b.askLevels[l.Price] = l.Quantity
+	}
+	b.stale = false
+}
+
+// StartBookBuilder subscribes to defaultPubSub and drives every Token's Book
+// from the decoded order/trade/cancel stream. It returns an unsubscribe
+// function; callers normally invoke this once at startup and never call the
+// returned function (process lifetime).
+func StartBookBuilder() (unsubscribe func()) {
+	return defaultPubSub.Subscribe(Filter{
+		Token:   TokenWildcard,
+		Classes: []MessageClass{ClassOrder, ClassTrade, ClassTradeCancel},
+	}, func(msg Msg) {
+		switch m := msg.Payload.(type) {
+		case *OrderMessage:
+			getBook(m.Token).applyOrder(msg.StreamID, m)
+		case *TradeMessage:
+			getBook(m.Token).applyTrade(msg.StreamID, m)
+		case *TradeCancelMessage:
+			getBook(m.Token).applyTradeCancel(msg.StreamID, m)
+		}
+	})
+}
+
+func sortedLevels(levels map[int32]int32, descending bool, depth int) []Level {
+	result := make([]Level, 0, len(levels))
+	for price, qty := range levels {
+		result = append(result, Level{Price: price, Quantity: qty})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if descending {
+			return result[i].Price > result[j].Price
+		}
+		return result[i].Price < result[j].Price
+	})
+	if depth >= 0 && len(result) > depth {
+		result = result[:depth]
+	}
+	return result
+}
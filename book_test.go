@@ -0,0 +1,123 @@
+package main
+
+import "testing"
+
+func TestBookApplyOrderAddModifyCancel(t *testing.T) {
+	b := newBook(101)
+
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 1, Token: 101, OrderType: 'B', Price: 100, Quantity: 10})
+	bids, _, _ := b.TopN(5)
+	if len(bids) != 1 || bids[0] != (Level{Price: 100, Quantity: 10}) {
+		t.Fatalf("unexpected bids after add: %+v", bids)
+	}
+
+	// Modify: NSE semantics treat this as cancel + add at the new price.
+	b.applyOrder(1, &OrderMessage{MessageType: 'M', OrderID: 1, Token: 101, OrderType: 'B', Price: 105, Quantity: 4})
+	bids, _, _ = b.TopN(5)
+	if len(bids) != 1 || bids[0] != (Level{Price: 105, Quantity: 4}) {
+		t.Fatalf("unexpected bids after modify: %+v", bids)
+	}
+
+	b.applyOrder(1, &OrderMessage{MessageType: 'X', OrderID: 1, Token: 101, OrderType: 'B', Price: 105, Quantity: 4})
+	bids, _, _ = b.TopN(5)
+	if len(bids) != 0 {
+		t.Fatalf("expected empty bids after cancel, got %+v", bids)
+	}
+}
+
+func TestBookTopNOrdering(t *testing.T) {
+	b := newBook(101)
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 1, OrderType: 'B', Price: 100, Quantity: 10})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 2, OrderType: 'B', Price: 110, Quantity: 5})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 3, OrderType: 'S', Price: 120, Quantity: 7})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 4, OrderType: 'S', Price: 115, Quantity: 3})
+
+	bids, asks, _ := b.TopN(5)
+	if len(bids) != 2 || bids[0].Price != 110 || bids[1].Price != 100 {
+		t.Fatalf("expected bids highest-first, got %+v", bids)
+	}
+	if len(asks) != 2 || asks[0].Price != 115 || asks[1].Price != 120 {
+		t.Fatalf("expected asks lowest-first, got %+v", asks)
+	}
+}
+
+func TestBookApplyTradeAndCancel(t *testing.T) {
+	b := newBook(101)
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 1, OrderType: 'B', Price: 100, Quantity: 10})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 2, OrderType: 'S', Price: 100, Quantity: 10})
+
+	b.applyTrade(1, &TradeMessage{BuyOrderID: 1, SellOrderID: 2, TradePrice: 100, TradeQty: 4})
+	bids, asks, _ := b.TopN(5)
+	if len(bids) != 1 || bids[0].Quantity != 6 {
+		t.Fatalf("expected resting bid qty 6 after partial fill, got %+v", bids)
+	}
+	if len(asks) != 1 || asks[0].Quantity != 6 {
+		t.Fatalf("expected resting ask qty 6 after partial fill, got %+v", asks)
+	}
+
+	b.applyTradeCancel(1, &TradeCancelMessage{BuyOrderID: 1, SellOrderID: 2, TradeQty: 4})
+	bids, asks, _ = b.TopN(5)
+	if len(bids) != 1 || bids[0].Quantity != 10 {
+		t.Fatalf("expected resting bid qty restored to 10, got %+v", bids)
+	}
+	if len(asks) != 1 || asks[0].Quantity != 10 {
+		t.Fatalf("expected resting ask qty restored to 10, got %+v", asks)
+	}
+}
+
+func TestBookTradeFullFillRemovesOrder(t *testing.T) {
+	b := newBook(101)
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 1, OrderType: 'B', Price: 100, Quantity: 5})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 2, OrderType: 'S', Price: 100, Quantity: 5})
+
+	b.applyTrade(1, &TradeMessage{BuyOrderID: 1, SellOrderID: 2, TradePrice: 100, TradeQty: 5})
+	bids, asks, _ := b.TopN(5)
+	if len(bids) != 0 || len(asks) != 0 {
+		t.Fatalf("expected both sides empty after a full fill, got bids=%+v asks=%+v", bids, asks)
+	}
+}
+
+func TestBookTradeCancelRestoresFullyFilledOrder(t *testing.T) {
+	b := newBook(101)
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 1, OrderType: 'B', Price: 100, Quantity: 5})
+	b.applyOrder(1, &OrderMessage{MessageType: 'N', OrderID: 2, OrderType: 'S', Price: 100, Quantity: 5})
+
+	b.applyTrade(1, &TradeMessage{BuyOrderID: 1, SellOrderID: 2, TradePrice: 100, TradeQty: 5})
+
+	// A TradeCancel for a trade that fully filled both legs must still
+	// restore the resting quantity, even though the orders no longer show
+	// up in TopN in between.
+	b.applyTradeCancel(1, &TradeCancelMessage{BuyOrderID: 1, SellOrderID: 2, TradeQty: 5})
+	bids, asks, _ := b.TopN(5)
+	if len(bids) != 1 || bids[0].Quantity != 5 {
+		t.Fatalf("expected resting bid qty restored to 5, got %+v", bids)
+	}
+	if len(asks) != 1 || asks[0].Quantity != 5 {
+		t.Fatalf("expected resting ask qty restored to 5, got %+v", asks)
+	}
+}
+
+func TestBookMarkStreamStaleAndResync(t *testing.T) {
+	b := getBook(202)
+	b.applyOrder(5, &OrderMessage{MessageType: 'N', OrderID: 1, OrderType: 'B', Price: 100, Quantity: 1})
+
+	MarkStreamStale(5)
+	if !b.Stale() {
+		t.Fatal("expected book to be marked stale for its stream")
+	}
+	if _, _, ok := b.TopN(5); ok {
+		t.Fatal("expected TopN to report not-ok while the book is stale")
+	}
+
+	b.Resync([]Level{{Price: 90, Quantity: 20}}, []Level{{Price: 95, Quantity: 15}})
+	if b.Stale() {
+		t.Fatal("expected Resync to clear Stale")
+	}
+	bids, asks, ok := b.TopN(5)
+	if !ok {
+		t.Fatal("expected TopN to report ok after Resync")
+	}
+	if len(bids) != 1 || bids[0].Price != 90 || len(asks) != 1 || asks[0].Price != 95 {
+		t.Fatalf("unexpected post-resync depth: bids=%+v asks=%+v", bids, asks)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+// FilterOpts describes an in-kernel BPF prefilter for a multicast socket:
+// accept only packets whose message type (payload byte 8, after the 8-byte
+// StreamHeader) and/or StreamID (header bytes 2-3) match. A zero-value field
+// matches everything for that predicate.
+type FilterOpts struct {
+	// MessageTypes restricts to packets whose first message body byte
+	// (offset 8) is one of these values, e.g. {'T', 'K'} for trade-only.
+	MessageTypes []byte
+	// StreamIDs restricts to packets whose StreamHeader.StreamID (offset 2,
+	// little endian int16) is one of these values.
+	StreamIDs []int16
+}
+
+// globalFilterOpts is the prefilter applied to every listenMulticast socket.
+// Left at its zero value (accept everything) unless the caller opts in.
+var globalFilterOpts FilterOpts
+
+// empty reports whether opts has no predicates configured, i.e. accepts
+// every packet.
+func (opts FilterOpts) empty() bool {
+	return len(opts.MessageTypes) == 0 && len(opts.StreamIDs) == 0
+}
+
+// ParseFilterOpts builds a FilterOpts from CLI-friendly comma-separated
+// strings: messageTypes is a list of single-character message type bytes
+// (e.g. "N,M,X,T"), streamIDs a list of integer StreamIDs. Either may be
+// empty, in which case that predicate matches everything, same as the zero
+// value FilterOpts.
+func ParseFilterOpts(messageTypes, streamIDs string) (FilterOpts, error) {
+	var opts FilterOpts
+
+	for _, s := range splitCSV(messageTypes) {
+		if len(s) != 1 {
+			return FilterOpts{}, fmt.Errorf("bpf message type %q: must be exactly one character", s)
+		}
+		opts.MessageTypes = append(opts.MessageTypes, s[0])
+	}
+
+	for _, s := range splitCSV(streamIDs) {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return FilterOpts{}, fmt.Errorf("bpf stream id %q: %w", s, err)
+		}
+		opts.StreamIDs = append(opts.StreamIDs, int16(id))
+	}
+
+	return opts, nil
+}
+
+// splitCSV splits a comma-separated flag value into trimmed, non-empty
+// fields, so "", " ", and "a,,b" all behave sensibly.
+func splitCSV(s string) []string {
+	var out []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+// messageTypeOffset and streamIDOffset locate the predicate fields within
+// the raw UDP payload delivered to a socket filter.
+const (
+	messageTypeOffset = 8
+	streamIDOffset    = 2
+)
+
+// BuildFilter assembles a classic BPF program implementing opts: it returns
+// the raw instructions ready for SO_ATTACH_FILTER. An empty FilterOpts
+// accepts every packet.
+func BuildFilter(opts FilterOpts) ([]bpf.RawInstruction, error) {
+	if len(opts.MessageTypes) == 0 && len(opts.StreamIDs) == 0 {
+		raw, err := bpf.Assemble([]bpf.Instruction{bpf.RetConstant{Val: 0xffff}})
+		if err != nil {
+			return nil, fmt.Errorf("assembling accept-all filter: %w", err)
+		}
+		return raw, nil
+	}
+
+	var insns []bpf.Instruction
+	if len(opts.StreamIDs) > 0 {
+		// classic BPF's 2-byte LoadAbsolute reads the field as network
+		// byte order (big endian), but StreamID is little endian on the
+		// wire, so the comparison value must be byte-swapped.
+		values := make([]uint32, len(opts.StreamIDs))
+		for i, id := range opts.StreamIDs {
+			values[i] = uint32(bits.ReverseBytes16(uint16(id)))
+		}
+		insns = append(insns, anyOfBlock(streamIDOffset, 2, values)...)
+	}
+	if len(opts.MessageTypes) > 0 {
+		values := make([]uint32, len(opts.MessageTypes))
+		for i, mt := range opts.MessageTypes {
+			values[i] = uint32(mt)
+		}
+		insns = append(insns, anyOfBlock(messageTypeOffset, 1, values)...)
+	}
+	insns = append(insns, bpf.RetConstant{Val: 0xffff}) // all blocks passed: accept
+
+	raw, err := bpf.Assemble(insns)
+	if err != nil {
+		return nil, fmt.Errorf("assembling filter: %w", err)
+	}
+	return raw, nil
+}
+
+// anyOfBlock builds a self-contained "load field at off/size, accept if it
+// equals any of values, otherwise reject" block. On a match it falls through
+// to whatever instruction follows the block (the next block, or the final
+// accept); on no match it returns 0 (reject) immediately, short-circuiting
+// the rest of the program.
+func anyOfBlock(off, size int, values []uint32) []bpf.Instruction {
+	insns := make([]bpf.Instruction, 0, len(values)+2)
+	insns = append(insns, bpf.LoadAbsolute{Off: uint32(off), Size: size})
+
+	n := len(values)
+	for j, v := range values {
+		insns = append(insns, bpf.JumpIf{
+			Cond:      bpf.JumpEqual,
+			Val:       v,
+			SkipTrue:  uint8(n - j), // skip past remaining checks + the reject below
+			SkipFalse: 0,
+		})
+	}
+	insns = append(insns, bpf.RetConstant{Val: 0}) // none matched: reject
+	return insns
+}
+
+// Matches reports whether payload (the raw UDP payload, StreamHeader
+// included) satisfies opts. This is the userspace equivalent of the program
+// BuildFilter assembles, used as a fallback on platforms without
+// SO_ATTACH_FILTER and as the filter predicate fed to the reader loop there.
+func (opts FilterOpts) Matches(payload []byte) bool {
+	if len(opts.StreamIDs) > 0 {
+		if len(payload) < streamIDOffset+2 {
+			return false
+		}
+		streamID := int16(uint16(payload[streamIDOffset]) | uint16(payload[streamIDOffset+1])<<8)
+		matched := false
+		for _, id := range opts.StreamIDs {
+			if id == streamID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(opts.MessageTypes) > 0 {
+		if len(payload) < messageTypeOffset+1 {
+			return false
+		}
+		msgType := payload[messageTypeOffset]
+		matched := false
+		for _, mt := range opts.MessageTypes {
+			if mt == msgType {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
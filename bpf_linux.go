@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// AttachFilter installs opts as an in-kernel BPF prefilter on conn via
+// SO_ATTACH_FILTER, so unmatched packets never traverse the userspace copy
+// or the Go scheduler. The returned apply function is an always-true
+// passthrough since the kernel has already done the filtering.
+func AttachFilter(conn *net.UDPConn, opts FilterOpts) (apply func(payload []byte) bool, err error) {
+	raw, err := BuildFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := make([]unix.SockFilter, len(raw))
+	for i, insn := range raw {
+		prog[i] = unix.SockFilter{Code: insn.Op, Jt: insn.Jt, Jf: insn.Jf, K: insn.K}
+	}
+	fprog := &unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("getting raw conn: %w", err)
+	}
+
+	var setsockoptErr error
+	controlErr := rawConn.Control(func(fd uintptr) {
+		setsockoptErr = unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, fprog)
+	})
+	if controlErr != nil {
+		return nil, fmt.Errorf("control: %w", controlErr)
+	}
+	if setsockoptErr != nil {
+		return nil, fmt.Errorf("SO_ATTACH_FILTER: %w", setsockoptErr)
+	}
+
+	return func(payload []byte) bool { return true }, nil
+}
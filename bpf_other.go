@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// AttachFilter has no SO_ATTACH_FILTER equivalent on this platform (BSD,
+// Windows, ...). It attaches nothing and instead returns opts.Matches as the
+// apply function, so the caller applies the same predicate in userspace
+// after the read instead of in the kernel.
+func AttachFilter(conn *net.UDPConn, opts FilterOpts) (apply func(payload []byte) bool, err error) {
+	return opts.Matches, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+// runFilter assembles raw into a bpf.VM and runs it against pkt, returning
+// whether the program accepted (kept) the packet.
+func runFilter(t *testing.T, raw []bpf.RawInstruction, pkt []byte) bool {
+	t.Helper()
+	insns := make([]bpf.Instruction, len(raw))
+	for i, ri := range raw {
+		insns[i] = ri.Disassemble()
+	}
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("bpf.NewVM: %v", err)
+	}
+	n, err := vm.Run(pkt)
+	if err != nil {
+		t.Fatalf("vm.Run: %v", err)
+	}
+	return n > 0
+}
+
+func TestBuildFilterStreamIDMatchesWireByteOrder(t *testing.T) {
+	raw, err := BuildFilter(FilterOpts{StreamIDs: []int16{1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pkt := make([]byte, messageTypeOffset+1)
+	// Wire format is little endian, so StreamID 1 is bytes {0x01, 0x00}.
+	pkt[streamIDOffset] = 0x01
+	pkt[streamIDOffset+1] = 0x00
+	if !runFilter(t, raw, pkt) {
+		t.Fatal("expected the assembled program to accept wire-format little-endian StreamID 1")
+	}
+
+	// The byte-swapped encoding must NOT match; if it does, the program is
+	// comparing against big-endian values instead of the wire's little
+	// endian StreamID.
+	pkt[streamIDOffset] = 0x00
+	pkt[streamIDOffset+1] = 0x01
+	if runFilter(t, raw, pkt) {
+		t.Fatal("program incorrectly accepted the byte-swapped (big-endian) StreamID encoding")
+	}
+}
+
+func TestFilterOptsMatchesMessageType(t *testing.T) {
+	opts := FilterOpts{MessageTypes: []byte{'T', 'K'}}
+
+	payload := make([]byte, messageTypeOffset+1)
+	payload[messageTypeOffset] = 'T'
+	if !opts.Matches(payload) {
+		t.Fatal("expected match on trade type")
+	}
+
+	payload[messageTypeOffset] = 'N'
+	if opts.Matches(payload) {
+		t.Fatal("expected no match for an order type when filtering trade-only")
+	}
+}
+
+func TestFilterOptsMatchesStreamID(t *testing.T) {
+	opts := FilterOpts{StreamIDs: []int16{3, 7}}
+
+	payload := make([]byte, streamIDOffset+2)
+	payload[streamIDOffset] = 7
+	if !opts.Matches(payload) {
+		t.Fatal("expected match on StreamID 7")
+	}
+
+	payload[streamIDOffset] = 9
+	if opts.Matches(payload) {
+		t.Fatal("expected no match for StreamID 9")
+	}
+}
+
+func TestFilterOptsMatchesShortPayload(t *testing.T) {
+	opts := FilterOpts{MessageTypes: []byte{'T'}}
+	if opts.Matches(nil) {
+		t.Fatal("expected no match for a payload too short to contain the message type")
+	}
+}
+
+func TestBuildFilterAcceptsAllWhenEmpty(t *testing.T) {
+	raw, err := BuildFilter(FilterOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(raw) == 0 {
+		t.Fatal("expected a non-empty accept-all program")
+	}
+}
+
+func TestBuildFilterCombinesPredicates(t *testing.T) {
+	raw, err := BuildFilter(FilterOpts{MessageTypes: []byte{'T'}, StreamIDs: []int16{1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(raw) == 0 {
+		t.Fatal("expected a non-empty combined program")
+	}
+
+	matching := make([]byte, messageTypeOffset+1)
+	matching[streamIDOffset], matching[streamIDOffset+1] = 0x01, 0x00 // wire LE StreamID 1
+	matching[messageTypeOffset] = 'T'
+	if !runFilter(t, raw, matching) {
+		t.Fatal("expected the combined program to accept a packet matching both predicates")
+	}
+
+	wrongType := append([]byte(nil), matching...)
+	wrongType[messageTypeOffset] = 'N'
+	if runFilter(t, raw, wrongType) {
+		t.Fatal("expected the combined program to reject a packet with the wrong message type")
+	}
+
+	wrongStream := append([]byte(nil), matching...)
+	wrongStream[streamIDOffset], wrongStream[streamIDOffset+1] = 0x02, 0x00 // wire LE StreamID 2
+	if runFilter(t, raw, wrongStream) {
+		t.Fatal("expected the combined program to reject a packet with the wrong StreamID")
+	}
+}
+
+func TestParseFilterOptsEmptyAcceptsAll(t *testing.T) {
+	opts, err := ParseFilterOpts("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.empty() {
+		t.Fatalf("expected empty flags to produce an accept-all FilterOpts, got %+v", opts)
+	}
+}
+
+func TestParseFilterOptsParsesBoth(t *testing.T) {
+	opts, err := ParseFilterOpts(" T, K ", "1, 7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := FilterOpts{MessageTypes: []byte{'T', 'K'}, StreamIDs: []int16{1, 7}}
+	if string(opts.MessageTypes) != string(want.MessageTypes) || len(opts.StreamIDs) != 2 || opts.StreamIDs[0] != 1 || opts.StreamIDs[1] != 7 {
+		t.Fatalf("unexpected opts: %+v", opts)
+	}
+}
+
+func TestParseFilterOptsRejectsMultiCharMessageType(t *testing.T) {
+	if _, err := ParseFilterOpts("TT", ""); err == nil {
+		t.Fatal("expected an error for a multi-character message type")
+	}
+}
+
+func TestParseFilterOptsRejectsNonIntegerStreamID(t *testing.T) {
+	if _, err := ParseFilterOpts("", "abc"); err == nil {
+		t.Fatal("expected an error for a non-integer stream id")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// CaptureWriter appends received multicast packets to a single merged pcap
+// file (LINKTYPE_RAW, synthesized IPv4/UDP headers, microsecond timestamps),
+// so captured exchange traffic can later be fed back through ReplayReader
+// for offline testing of the arbitrator, gap recovery, and decoders.
+type CaptureWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewCaptureWriter creates path and writes the pcap global header.
+func NewCaptureWriter(path string) (*CaptureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating capture file %s: %w", path, err)
+	}
+
+	hdr := pcapGlobalHeader{
+		MagicNumber:  pcapMagicMicroseconds,
+		VersionMajor: pcapVersionMajor,
+		VersionMinor: pcapVersionMinor,
+		SnapLen:      pcapSnapLen,
+		Network:      linkTypeRaw,
+	}
+	if err := binary.Write(file, binary.LittleEndian, hdr); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("writing pcap global header: %w", err)
+	}
+
+	return &CaptureWriter{file: file}, nil
+}
+
+// WritePacket appends one captured datagram, reconstructing IPv4/UDP headers
+// around payload so the original source/destination survive into the pcap.
+func (w *CaptureWriter) WritePacket(ts time.Time, srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte) error {
+	frame, err := synthesizeIPv4UDP(srcIP, dstIP, srcPort, dstPort, payload)
+	if err != nil {
+		return err
+	}
+
+	hdr := pcapPacketHeader{
+		TsSec:   uint32(ts.Unix()),
+		TsUsec:  uint32(ts.Nanosecond() / 1000),
+		InclLen: uint32(len(frame)),
+		OrigLen: uint32(len(frame)),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := binary.Write(w.file, binary.LittleEndian, hdr); err != nil {
+		return fmt.Errorf("writing pcap packet header: %w", err)
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return fmt.Errorf("writing pcap frame: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the underlying pcap file.
+func (w *CaptureWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
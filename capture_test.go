@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCaptureReplayRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+
+	writer, err := NewCaptureWriter(path)
+	if err != nil {
+		t.Fatalf("NewCaptureWriter: %v", err)
+	}
+
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8, 'N'}
+	ts := time.Unix(1700000000, 123000)
+	if err := writer.WritePacket(ts, net.ParseIP("10.0.0.1"), net.ParseIP("239.70.70.41"), 17741, 17741, payload); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reader, err := NewReplayReader(path)
+	if err != nil {
+		t.Fatalf("NewReplayReader: %v", err)
+	}
+	defer reader.Close()
+
+	pkt, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if !pkt.DstIP.Equal(net.ParseIP("239.70.70.41")) || pkt.DstPort != 17741 {
+		t.Fatalf("unexpected destination: %s:%d", pkt.DstIP, pkt.DstPort)
+	}
+	if !pkt.SrcIP.Equal(net.ParseIP("10.0.0.1")) || pkt.SrcPort != 17741 {
+		t.Fatalf("unexpected source: %s:%d", pkt.SrcIP, pkt.SrcPort)
+	}
+	if string(pkt.Payload) != string(payload) {
+		t.Fatalf("payload mismatch: got %v want %v", pkt.Payload, payload)
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF after the only packet, got %v", err)
+	}
+}
+
+func TestFindStreamBySocketMatchesSource1(t *testing.T) {
+	stream, source, ok := findStreamBySocket(net.ParseIP(foStreams[0].Source1IP), foStreams[0].Source1Port)
+	if !ok || source != "Source1" || stream.StreamName != foStreams[0].StreamName {
+		t.Fatalf("expected a Source1 match for %s, got stream=%+v source=%s ok=%v", foStreams[0].StreamName, stream, source, ok)
+	}
+}
+
+func TestFindStreamBySocketNoMatch(t *testing.T) {
+	if _, _, ok := findStreamBySocket(net.ParseIP("1.2.3.4"), 9999); ok {
+		t.Fatal("expected no match for an unconfigured socket")
+	}
+}
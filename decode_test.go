@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// putOrderBody hand-crafts an OrderMessage-shaped wire payload.
+func putOrderBody(msgType, orderType byte, timestamp int64, orderID float64, token, price, quantity int32) []byte {
+	buf := make([]byte, orderMessageSize)
+	buf[0] = msgType
+	binary.LittleEndian.PutUint64(buf[1:9], uint64(timestamp))
+	binary.LittleEndian.PutUint64(buf[9:17], math.Float64bits(orderID))
+	binary.LittleEndian.PutUint32(buf[17:21], uint32(token))
+	buf[21] = orderType
+	binary.LittleEndian.PutUint32(buf[22:26], uint32(price))
+	binary.LittleEndian.PutUint32(buf[26:30], uint32(quantity))
+	return buf
+}
+
+// putTradeBody hand-crafts a TradeMessage/TradeCancelMessage-shaped payload.
+func putTradeBody(msgType byte, timestamp int64, buyOrderID, sellOrderID float64, token, tradePrice, tradeQty int32) []byte {
+	buf := make([]byte, tradeMessageSize)
+	buf[0] = msgType
+	binary.LittleEndian.PutUint64(buf[1:9], uint64(timestamp))
+	binary.LittleEndian.PutUint64(buf[9:17], math.Float64bits(buyOrderID))
+	binary.LittleEndian.PutUint64(buf[17:25], math.Float64bits(sellOrderID))
+	binary.LittleEndian.PutUint32(buf[25:29], uint32(token))
+	binary.LittleEndian.PutUint32(buf[29:33], uint32(tradePrice))
+	binary.LittleEndian.PutUint32(buf[33:37], uint32(tradeQty))
+	return buf
+}
+
+func TestOrderMessageUnmarshalBinary(t *testing.T) {
+	data := putOrderBody('N', 'B', 1234567890, 42.0, 101, 25050, 100)
+
+	var msg OrderMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.MessageType != 'N' || msg.OrderType != 'B' {
+		t.Fatalf("got MessageType=%c OrderType=%c", msg.MessageType, msg.OrderType)
+	}
+	if msg.Timestamp != 1234567890 || msg.OrderID != 42.0 || msg.Token != 101 || msg.Price != 25050 || msg.Quantity != 100 {
+		t.Fatalf("unexpected decoded fields: %+v", msg)
+	}
+}
+
+func TestOrderMessageUnmarshalBinaryShortBuffer(t *testing.T) {
+	var msg OrderMessage
+	if err := msg.UnmarshalBinary(make([]byte, orderMessageSize-1)); err == nil {
+		t.Fatal("expected error for short buffer, got nil")
+	}
+}
+
+func TestOrderMessageUnmarshalBinaryZeroPrice(t *testing.T) {
+	data := putOrderBody('N', 'S', 1, 1.0, 1, 0, 1)
+
+	var msg OrderMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Price != 0 {
+		t.Fatalf("expected Price=0, got %d", msg.Price)
+	}
+}
+
+func TestTradeMessageUnmarshalBinary(t *testing.T) {
+	data := putTradeBody('T', 999, 10.5, 20.5, 55, 30000, 5)
+
+	var msg TradeMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.BuyOrderID != 10.5 || msg.SellOrderID != 20.5 || msg.Token != 55 || msg.TradePrice != 30000 || msg.TradeQty != 5 {
+		t.Fatalf("unexpected decoded fields: %+v", msg)
+	}
+}
+
+func TestTradeMessageUnmarshalBinaryShortBuffer(t *testing.T) {
+	var msg TradeMessage
+	if err := msg.UnmarshalBinary(make([]byte, 10)); err == nil {
+		t.Fatal("expected error for short buffer, got nil")
+	}
+}
+
+func TestSpreadOrderMessageUnmarshalBinaryNegativePrice(t *testing.T) {
+	data := putOrderBody('G', 'S', 1, 1.0, 7, -150, 10)
+
+	var msg SpreadOrderMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Price != -150 {
+		t.Fatalf("expected Price=-150, got %d", msg.Price)
+	}
+}
+
+func TestSpreadTradeMessageUnmarshalBinary(t *testing.T) {
+	data := putTradeBody('K', 42, 1.0, 2.0, 9, -75, 3)
+
+	var msg SpreadTradeMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.TradePrice != -75 || msg.Quantity != 3 {
+		t.Fatalf("unexpected decoded fields: %+v", msg)
+	}
+}
+
+func TestTradeCancelMessageUnmarshalBinary(t *testing.T) {
+	data := putTradeBody('C', 7, 3.0, 4.0, 11, 12345, 2)
+
+	var msg TradeCancelMessage
+	if err := msg.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Token != 11 || msg.TradePrice != 12345 || msg.TradeQty != 2 {
+		t.Fatalf("unexpected decoded fields: %+v", msg)
+	}
+}
+
+func TestHeartbeatMessageUnmarshalBinary(t *testing.T) {
+	buf := make([]byte, heartbeatMessageSize)
+	buf[0] = 'Z'
+	binary.LittleEndian.PutUint32(buf[1:5], 999)
+
+	var msg HeartbeatMessage
+	if err := msg.UnmarshalBinary(buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.LastSeqNo != 999 {
+		t.Fatalf("expected LastSeqNo=999, got %d", msg.LastSeqNo)
+	}
+}
+
+func TestDecodeMessageDispatch(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want interface{}
+	}{
+		{"order", putOrderBody('N', 'B', 1, 1.0, 1, 100, 1), &OrderMessage{}},
+		{"trade", putTradeBody('T', 1, 1.0, 2.0, 1, 100, 1), &TradeMessage{}},
+		{"spread order", putOrderBody('G', 'B', 1, 1.0, 1, 100, 1), &SpreadOrderMessage{}},
+		{"spread trade", putTradeBody('K', 1, 1.0, 2.0, 1, 100, 1), &SpreadTradeMessage{}},
+		{"trade cancel", putTradeBody('C', 1, 1.0, 2.0, 1, 100, 1), &TradeCancelMessage{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeMessage(tc.data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			switch got.(type) {
+			case *OrderMessage, *TradeMessage, *SpreadOrderMessage, *SpreadTradeMessage, *TradeCancelMessage:
+			default:
+				t.Fatalf("unexpected type %T", got)
+			}
+			_ = tc.want
+		})
+	}
+}
+
+func TestDecodeMessageUnknownType(t *testing.T) {
+	if _, err := DecodeMessage([]byte{'?'}); err == nil {
+		t.Fatal("expected error for unknown message type, got nil")
+	}
+}
+
+func TestDecodeMessageEmptyBuffer(t *testing.T) {
+	if _, err := DecodeMessage(nil); err == nil {
+		t.Fatal("expected error for empty buffer, got nil")
+	}
+}
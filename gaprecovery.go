@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Gap recovery tuning knobs.
+const (
+	// gapReorderGrace is how long we hold newer, out-of-order packets before
+	// giving up on the arbitrator filling the gap from the other source and
+	// issuing a TCP retransmission request.
+	gapReorderGrace = 50 * time.Millisecond
+
+	// maxOutstandingRequests caps concurrent retransmission requests per
+	// (StreamID, Source) so a bad recovery link can't pile up unbounded work.
+	maxOutstandingRequests = 8
+
+	// recoveryBackoffBase/Max bound the exponential backoff applied after a
+	// retransmission request fails.
+	recoveryBackoffBase = 200 * time.Millisecond
+	recoveryBackoffMax  = 10 * time.Second
+
+	// maxGapRecoveryAttempts bounds how many times a single gap is retried
+	// over the recovery link before it is abandoned: the arrival rate of a
+	// live feed matters more than filling every last gap, so after this many
+	// failures (or failures to fully fill the range) we fast-forward past
+	// it instead of retrying forever.
+	maxGapRecoveryAttempts = 5
+
+	// maxReorderQueueLen bounds, per (StreamID, Source), how many
+	// out-of-order packets Observe will buffer while a gap is outstanding.
+	// If a misconfigured or unreachable RecoveryAddr lets this fill up, the
+	// oldest outstanding gap is abandoned so the feed keeps flowing,
+	// degraded, instead of growing the queue without bound.
+	maxReorderQueueLen = 2048
+
+	// recoveryResponseTimeout bounds how long requestRetransmit waits for
+	// recovered packets to arrive over the TCP recovery socket after sending
+	// a request.
+	recoveryResponseTimeout = 2 * time.Second
+)
+
+// recoveryRequestHeader mirrors NSE's MTBT retransmission request message:
+// a fixed header followed by the start/end sequence of the missing range.
+// Pragma Pack 1, Little Endian.
+type recoveryRequestHeader struct {
+	MsgLen     int16
+	StreamID   int16
+	StartSeqNo uint32
+	EndSeqNo   uint32
+}
+
+// MarshalBinary encodes a recoveryRequestHeader per the MTBT recovery format.
+func (h recoveryRequestHeader) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.LittleEndian, h); err != nil {
+		return nil, fmt.Errorf("encoding recovery request: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// pendingPacket is a newer-than-expected packet held in the reorder queue
+// while we wait for the gap to be filled.
+type pendingPacket struct {
+	header *StreamHeader
+	data   []byte
+	source string
+}
+
+// GapRecovery tracks expected sequence numbers per (StreamID, Source) and
+// drives retransmission requests over a companion TCP recovery socket when a
+// gap isn't filled by the arbitrator within the grace window.
+type GapRecovery struct {
+	mu                  sync.Mutex
+	stream              FOStream
+	expected            map[string]uint32 // keyed by source
+	reorderQueue        map[string][]pendingPacket
+	outstandingRequests int
+	backoff             time.Duration
+	maxAttempts         int
+	recoveryAddr        string
+	deliver             func(header *StreamHeader, data []byte, streamName, source string)
+
+	// onGapAbandoned, if set, is invoked (without g.mu held) whenever a gap
+	// is given up on rather than filled, so dependent order books can be
+	// marked Stale the same way they are for an arbitrator late drop.
+	onGapAbandoned func()
+
+	gapsDetected  uint64
+	gapsAbandoned uint64
+}
+
+var (
+	gapRecoveries      = make(map[int]*GapRecovery)
+	gapRecoveriesMutex sync.Mutex
+)
+
+// newGapRecovery builds a GapRecovery for stream. recoveryAddr is the
+// companion TCP socket used for retransmission requests (host:port); deliver
+// is called with recovered/filled packets so they re-enter the normal
+// processing pipeline; onGapAbandoned is called whenever a gap can't be
+// filled and is skipped instead.
+func newGapRecovery(stream FOStream, recoveryAddr string, deliver func(header *StreamHeader, data []byte, streamName, source string), onGapAbandoned func()) *GapRecovery {
+	return &GapRecovery{
+		stream:         stream,
+		expected:       make(map[string]uint32),
+		reorderQueue:   make(map[string][]pendingPacket),
+		backoff:        recoveryBackoffBase,
+		maxAttempts:    maxGapRecoveryAttempts,
+		recoveryAddr:   recoveryAddr,
+		deliver:        deliver,
+		onGapAbandoned: onGapAbandoned,
+	}
+}
+
+// getGapRecovery returns the GapRecovery for stream, creating it on first use.
+func getGapRecovery(stream FOStream, recoveryAddr string, deliver func(header *StreamHeader, data []byte, streamName, source string), onGapAbandoned func()) *GapRecovery {
+	gapRecoveriesMutex.Lock()
+	defer gapRecoveriesMutex.Unlock()
+
+	g := gapRecoveries[stream.StreamID]
+	if g == nil {
+		g = newGapRecovery(stream, recoveryAddr, deliver, onGapAbandoned)
+		gapRecoveries[stream.StreamID] = g
+	}
+	return g
+}
+
+// pipelineGapRecovery returns the GapRecovery that arbitrates and decodes
+// packets for stream, shared by live multicast ingestion (listenMulticast)
+// and pcap replay (RunReplay) so both paths exercise the identical
+// arbitrate -> decode -> publish pipeline.
+func pipelineGapRecovery(stream FOStream) *GapRecovery {
+	arbitrator := getArbitrator(stream.StreamID)
+	arbitrator.SetOnLateDrop(func() { MarkStreamStale(stream.StreamID) })
+
+	return getGapRecovery(stream, stream.RecoveryAddr, func(header *StreamHeader, data []byte, streamName, source string) {
+		forward, lateDrop := arbitrator.Arbitrate(header.SequenceNo)
+		if lateDrop {
+			log.Printf("[%s-%s] Late drop: seq=%d arrived past window low-water mark", streamName, source, header.SequenceNo)
+			return
+		}
+		if !forward {
+			return
+		}
+		if err := processMessage(data, stream.StreamID, streamName, source); err != nil {
+			log.Printf("[%s-%s] Error processing message: %v", streamName, source, err)
+		}
+	}, func() { MarkStreamStale(stream.StreamID) })
+}
+
+// Observe checks seq against the expected sequence for source. If seq is in
+// order it is delivered immediately. If seq is ahead of expected, a gap is
+// detected: the packet is buffered and a timer is armed to request
+// retransmission if the gap isn't filled within gapReorderGrace. If the
+// reorder queue is already full, the outstanding gap is abandoned instead of
+// growing the queue further.
+func (g *GapRecovery) Observe(header *StreamHeader, data []byte, source string) {
+	g.mu.Lock()
+
+	expected, known := g.expected[source]
+	if !known {
+		g.expected[source] = header.SequenceNo + 1
+		g.mu.Unlock()
+		g.deliver(header, data, g.stream.StreamName, source)
+		return
+	}
+
+	switch {
+	case header.SequenceNo == expected:
+		g.expected[source] = expected + 1
+		drained := g.drainQueue(source)
+		g.mu.Unlock()
+		// header/data is the lowest sequence in this newly-contiguous run
+		// (it's exactly the old expected value), so it must be delivered
+		// before whatever drainQueue just unblocked, which all sits above it.
+		g.deliver(header, data, g.stream.StreamName, source)
+		for _, pkt := range drained {
+			g.deliver(pkt.header, pkt.data, g.stream.StreamName, source)
+		}
+
+	case header.SequenceNo > expected:
+		missingStart, missingEnd := expected, header.SequenceNo-1
+		if len(g.reorderQueue[source]) >= maxReorderQueueLen {
+			g.mu.Unlock()
+			g.abandonGap(source, missingEnd, fmt.Sprintf("reorder queue exceeded %d buffered packets", maxReorderQueueLen))
+			g.Observe(header, data, source)
+			return
+		}
+		g.reorderQueue[source] = append(g.reorderQueue[source], pendingPacket{header: header, data: data, source: source})
+		g.gapsDetected++
+		g.mu.Unlock()
+		log.Printf("[%s-%s] GapDetected: missing seq %d-%d", g.stream.StreamName, source, missingStart, missingEnd)
+		time.AfterFunc(gapReorderGrace, func() {
+			g.resolveGap(source, missingStart, missingEnd, 0)
+		})
+
+	default:
+		// Older than expected: the arbitrator already dealt with dedup/late
+		// drops, so this is most likely a retransmitted packet arriving
+		// after the gap was otherwise filled. Drop silently.
+		g.mu.Unlock()
+	}
+}
+
+// drainQueue removes and returns, in ascending sequence order, any buffered
+// packets that are now contiguous with g.expected[source]. Callers must hold
+// g.mu while calling drainQueue, but must deliver the returned packets (in
+// order) only after releasing it.
+func (g *GapRecovery) drainQueue(source string) []pendingPacket {
+	queue := g.reorderQueue[source]
+	if len(queue) == 0 {
+		return nil
+	}
+	sort.Slice(queue, func(i, j int) bool { return queue[i].header.SequenceNo < queue[j].header.SequenceNo })
+
+	var drained []pendingPacket
+	remaining := queue[:0]
+	for _, pkt := range queue {
+		if pkt.header.SequenceNo == g.expected[source] {
+			g.expected[source]++
+			drained = append(drained, pkt)
+		} else if pkt.header.SequenceNo > g.expected[source] {
+			remaining = append(remaining, pkt)
+		}
+	}
+	g.reorderQueue[source] = remaining
+	return drained
+}
+
+// abandonGap gives up on filling [?, end] for source: expected is
+// fast-forwarded past end (unless something has already filled it), any
+// now-contiguous buffered packets are delivered, and onGapAbandoned fires so
+// dependents can be marked Stale the same way a late drop would.
+func (g *GapRecovery) abandonGap(source string, end uint32, reason string) {
+	g.mu.Lock()
+	if g.expected[source] <= end {
+		g.expected[source] = end + 1
+	}
+	drained := g.drainQueue(source)
+	g.gapsAbandoned++
+	onGapAbandoned := g.onGapAbandoned
+	g.mu.Unlock()
+
+	log.Printf("[%s-%s] GapAbandoned: %s, resuming at seq %d", g.stream.StreamName, source, reason, end+1)
+	for _, pkt := range drained {
+		g.deliver(pkt.header, pkt.data, g.stream.StreamName, source)
+	}
+	if onGapAbandoned != nil {
+		onGapAbandoned()
+	}
+}
+
+// resolveGap fires once the grace window has elapsed: if the gap is still
+// outstanding (the other source via the arbitrator, or a later packet,
+// hasn't filled it), issue a TCP retransmission request. After maxAttempts
+// failed or partial attempts it abandons the gap rather than retrying
+// forever.
+func (g *GapRecovery) resolveGap(source string, start, end uint32, attempt int) {
+	g.mu.Lock()
+	if g.expected[source] > end {
+		// Already filled while we were waiting.
+		g.mu.Unlock()
+		return
+	}
+	if attempt >= g.maxAttempts {
+		g.mu.Unlock()
+		g.abandonGap(source, end, fmt.Sprintf("exceeded %d recovery attempts", attempt))
+		return
+	}
+	if g.outstandingRequests >= maxOutstandingRequests {
+		g.mu.Unlock()
+		log.Printf("[%s-%s] Gap recovery skipped: MaxOutstandingRequests reached", g.stream.StreamName, source)
+		return
+	}
+	g.outstandingRequests++
+	backoff := g.backoff
+	g.mu.Unlock()
+
+	if err := g.requestRetransmit(source, start, end); err != nil {
+		log.Printf("[%s-%s] Retransmission request failed (attempt %d): %v", g.stream.StreamName, source, attempt+1, err)
+		g.mu.Lock()
+		g.outstandingRequests--
+		g.backoff = minDuration(g.backoff*2, recoveryBackoffMax)
+		g.mu.Unlock()
+		time.AfterFunc(backoff, func() { g.resolveGap(source, start, end, attempt+1) })
+		return
+	}
+
+	g.mu.Lock()
+	g.outstandingRequests--
+	g.backoff = recoveryBackoffBase
+	stillOpen := g.expected[source] <= end
+	g.mu.Unlock()
+
+	if stillOpen {
+		// The recovery link answered but the response didn't cover the
+		// whole range (e.g. it was cut short); retry for what's left.
+		time.AfterFunc(backoff, func() { g.resolveGap(source, start, end, attempt+1) })
+	}
+}
+
+// requestRetransmit sends a recovery request for [start, end] over the
+// companion TCP recovery socket, then reads recovered packets off the same
+// connection and feeds them back through Observe so they re-enter the
+// normal arbitrate -> decode -> publish pipeline.
+func (g *GapRecovery) requestRetransmit(source string, start, end uint32) error {
+	conn, err := net.DialTimeout("tcp", g.recoveryAddr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("dialing recovery socket %s: %w", g.recoveryAddr, err)
+	}
+	defer conn.Close()
+
+	req := recoveryRequestHeader{
+		MsgLen:     16,
+		StreamID:   int16(g.stream.StreamID),
+		StartSeqNo: start,
+		EndSeqNo:   end,
+	}
+	payload, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return fmt.Errorf("setting write deadline: %w", err)
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return fmt.Errorf("writing recovery request: %w", err)
+	}
+
+	log.Printf("[%s] Recovery request sent for seq %d-%d via %s", g.stream.StreamName, start, end, g.recoveryAddr)
+
+	return g.readRecoveryResponse(conn, source, end)
+}
+
+// readRecoveryResponse reads packets off conn, each framed identically to
+// the live multicast feed (an 8-byte StreamHeader, MsgLen counting the
+// header itself, followed by the message body), until the gap for source is
+// filled through end or the connection stalls. Each recovered packet is fed
+// through Observe, same as a live packet, so dedup/reorder/delivery stay in
+// one place.
+func (g *GapRecovery) readRecoveryResponse(conn net.Conn, source string, end uint32) error {
+	if err := conn.SetReadDeadline(time.Now().Add(recoveryResponseTimeout)); err != nil {
+		return fmt.Errorf("setting read deadline: %w", err)
+	}
+
+	received := 0
+	for {
+		g.mu.Lock()
+		filled := g.expected[source] > end
+		g.mu.Unlock()
+		if filled {
+			return nil
+		}
+
+		var hdrBuf [8]byte
+		if _, err := io.ReadFull(conn, hdrBuf[:]); err != nil {
+			if received > 0 {
+				// Partial fill: whatever arrived was already delivered via
+				// Observe above; resolveGap will retry for what's left.
+				return nil
+			}
+			return fmt.Errorf("reading recovery response header: %w", err)
+		}
+
+		header, err := parseStreamHeader(hdrBuf[:])
+		if err != nil {
+			return fmt.Errorf("parsing recovery response header: %w", err)
+		}
+		bodyLen := int(header.MsgLen) - len(hdrBuf)
+		if bodyLen < 0 {
+			return fmt.Errorf("recovery response: invalid MsgLen %d", header.MsgLen)
+		}
+		body := make([]byte, bodyLen)
+		if bodyLen > 0 {
+			if _, err := io.ReadFull(conn, body); err != nil {
+				return fmt.Errorf("reading recovery response body: %w", err)
+			}
+		}
+
+		received++
+		g.Observe(header, body, source)
+	}
+}
+
+// Stats returns the number of sequence gaps detected for this stream so far
+// and how many of those were ultimately abandoned (never filled by reorder,
+// the other source, or a TCP retransmission request).
+func (g *GapRecovery) Stats() (gapsDetected, gapsAbandoned uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gapsDetected, g.gapsAbandoned
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
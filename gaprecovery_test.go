@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestGapRecoveryFillsGapBeforeGraceWindow(t *testing.T) {
+	stream := FOStream{StreamName: "TEST", StreamID: 9001}
+	var mu sync.Mutex
+	var delivered []uint32
+	g := newGapRecovery(stream, "", func(header *StreamHeader, data []byte, streamName, source string) {
+		mu.Lock()
+		delivered = append(delivered, header.SequenceNo)
+		mu.Unlock()
+	}, func() { t.Error("unexpected gap abandonment: the gap was filled before the grace window elapsed") })
+
+	g.Observe(&StreamHeader{SequenceNo: 1}, nil, "Source1")
+	g.Observe(&StreamHeader{SequenceNo: 3}, nil, "Source1") // gap: missing seq 2
+	g.Observe(&StreamHeader{SequenceNo: 2}, nil, "Source1") // fills it, e.g. via the other source, well within gapReorderGrace
+
+	mu.Lock()
+	got := append([]uint32(nil), delivered...)
+	mu.Unlock()
+	if want := []uint32{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected delivered seqs %v in sequence order, got %v", want, got)
+	}
+
+	gapsDetected, gapsAbandoned := g.Stats()
+	if gapsDetected != 1 || gapsAbandoned != 0 {
+		t.Fatalf("expected 1 gap detected and 0 abandoned, got detected=%d abandoned=%d", gapsDetected, gapsAbandoned)
+	}
+}
+
+// TestGapRecoveryDeliversMultiPacketFillInSequenceOrder covers a gap more
+// than one packet wide filling in a single drain: every buffered packet must
+// reach deliver in ascending sequence order, not the order Observe happened
+// to see them in (and not reversed by a LIFO defer inside drainQueue).
+func TestGapRecoveryDeliversMultiPacketFillInSequenceOrder(t *testing.T) {
+	stream := FOStream{StreamName: "TEST", StreamID: 9003}
+	var mu sync.Mutex
+	var delivered []uint32
+	g := newGapRecovery(stream, "", func(header *StreamHeader, data []byte, streamName, source string) {
+		mu.Lock()
+		delivered = append(delivered, header.SequenceNo)
+		mu.Unlock()
+	}, func() { t.Error("unexpected gap abandonment") })
+
+	g.Observe(&StreamHeader{SequenceNo: 1}, nil, "Source1")
+	g.Observe(&StreamHeader{SequenceNo: 4}, nil, "Source1") // gap: missing 2-3
+	g.Observe(&StreamHeader{SequenceNo: 3}, nil, "Source1") // still missing 2
+	g.Observe(&StreamHeader{SequenceNo: 2}, nil, "Source1") // fills the gap; 3 and 4 are now contiguous too
+
+	mu.Lock()
+	got := append([]uint32(nil), delivered...)
+	mu.Unlock()
+	if want := []uint32{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected delivered seqs %v in sequence order, got %v", want, got)
+	}
+}
+
+// TestGapRecoveryAbandonsGapWhenReorderQueueFills covers the never-fill path:
+// recoveryAddr is unreachable (as it is on every real FOStream until it's
+// configured), and the gap is never closed by a later packet, so the
+// reorder queue would otherwise grow without bound. It must instead be
+// abandoned so the feed keeps flowing, degraded.
+func TestGapRecoveryAbandonsGapWhenReorderQueueFills(t *testing.T) {
+	stream := FOStream{StreamName: "TEST", StreamID: 9002}
+	var mu sync.Mutex
+	var delivered []uint32
+	abandoned := 0
+	g := newGapRecovery(stream, "", func(header *StreamHeader, data []byte, streamName, source string) {
+		mu.Lock()
+		delivered = append(delivered, header.SequenceNo)
+		mu.Unlock()
+	}, func() {
+		mu.Lock()
+		abandoned++
+		mu.Unlock()
+	})
+
+	g.Observe(&StreamHeader{SequenceNo: 1}, nil, "Source1") // establishes expected=2
+
+	// Sequence 2 never arrives. Flood enough out-of-order packets to exceed
+	// maxReorderQueueLen so Observe abandons the gap itself rather than
+	// waiting on the grace window and the (here unreachable) recovery link.
+	for seq := uint32(3); seq <= 3+maxReorderQueueLen; seq++ {
+		g.Observe(&StreamHeader{SequenceNo: seq}, nil, "Source1")
+	}
+
+	mu.Lock()
+	abandonedCount, deliveredCount := abandoned, len(delivered)
+	mu.Unlock()
+
+	if abandonedCount == 0 {
+		t.Fatal("expected the unfillable gap to be abandoned once the reorder queue filled")
+	}
+	if deliveredCount == 0 {
+		t.Fatal("expected delivery to resume once the gap was abandoned")
+	}
+
+	gapsDetected, gapsAbandoned := g.Stats()
+	if gapsDetected == 0 || gapsAbandoned == 0 {
+		t.Fatalf("expected Stats to report detected and abandoned gaps, got detected=%d abandoned=%d", gapsDetected, gapsAbandoned)
+	}
+
+	g.mu.Lock()
+	queueLen := len(g.reorderQueue["Source1"])
+	g.mu.Unlock()
+	if queueLen > maxReorderQueueLen {
+		t.Fatalf("expected the reorder queue to stay at or under %d entries, got %d", maxReorderQueueLen, queueLen)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
 // MTBT Protocol Structures based on NSE API Specification v6.7
@@ -92,17 +97,30 @@ type FOStream struct {
 	Source2IP     string
 	Source2Port   int
 	ApproxBandwidth string
+
+	// RecoveryAddr is the companion TCP socket ("host:port") used to request
+	// retransmission of missing sequences per NSE's MTBT recovery spec. Left
+	// unset here since it is circular-specific; populate per exchange
+	// connectivity documentation before enabling live gap recovery.
+	RecoveryAddr string
 }
 
 // Statistics for monitoring
 type StreamStats struct {
 	mu              sync.Mutex
 	PacketsReceived uint64
+	BytesReceived   uint64
 	LastSequence    uint32
 	OrderCount      uint64
 	TradeCount      uint64
 	ErrorCount      uint64
 	LastUpdate      time.Time
+
+	// LastIfIndex and LastTTL are taken from the most recent packet's
+	// ipv4.ControlMessage, for diagnosing which NIC traffic is actually
+	// arriving on and catching TTL anomalies.
+	LastIfIndex int
+	LastTTL     int
 }
 
 var (
@@ -147,50 +165,58 @@ func parseStreamHeader(data []byte) (*StreamHeader, error) {
 	return header, nil
 }
 
-// processMessage processes different message types
-func processMessage(data []byte, streamName string, source string) error {
-	if len(data) < 1 {
-		return fmt.Errorf("empty message data")
+// processMessage decodes a message body, updates stats/logs per message
+// class, and publishes the decoded Msg to defaultPubSub so subscribers can
+// consume it without re-parsing. The heavy lifting (wire layout, offsets)
+// lives in DecodeMessage.
+func processMessage(data []byte, streamID int, streamName string, source string) error {
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		return err
 	}
 
-	msgType := data[0]
 	stats := getStreamStats(streamName, source)
+	msg := Msg{StreamID: streamID, StreamName: streamName, Source: source, Payload: decoded}
 
-	switch msgType {
-	case 'N', 'M', 'X': // Order Messages
+	switch m := decoded.(type) {
+	case *OrderMessage:
 		stats.mu.Lock()
 		stats.OrderCount++
 		stats.mu.Unlock()
-		log.Printf("[%s-%s] Order Message: Type=%c, DataLen=%d", streamName, source, msgType, len(data))
+		log.Printf("[%s-%s] Order Message: Type=%c, Token=%d, Price=%d, Qty=%d", streamName, source, m.MessageType, m.Token, m.Price, m.Quantity)
+		msg.Token, msg.Class = m.Token, ClassOrder
 
-	case 'T': // Trade Message
+	case *TradeMessage:
 		stats.mu.Lock()
 		stats.TradeCount++
 		stats.mu.Unlock()
-		log.Printf("[%s-%s] Trade Message: Type=%c, DataLen=%d", streamName, source, msgType, len(data))
+		log.Printf("[%s-%s] Trade Message: Token=%d, Price=%d, Qty=%d", streamName, source, m.Token, m.TradePrice, m.TradeQty)
+		msg.Token, msg.Class = m.Token, ClassTrade
 
-	case 'G', 'H', 'J': // Spread Order Messages
+	case *SpreadOrderMessage:
 		stats.mu.Lock()
 		stats.OrderCount++
 		stats.mu.Unlock()
-		log.Printf("[%s-%s] Spread Order Message: Type=%c, DataLen=%d", streamName, source, msgType, len(data))
+		log.Printf("[%s-%s] Spread Order Message: Type=%c, Token=%d, Price=%d, Qty=%d", streamName, source, m.MessageType, m.Token, m.Price, m.Quantity)
+		msg.Token, msg.Class = m.Token, ClassSpreadOrder
 
-	case 'K': // Spread Trade Message
+	case *SpreadTradeMessage:
 		stats.mu.Lock()
 		stats.TradeCount++
 		stats.mu.Unlock()
-		log.Printf("[%s-%s] Spread Trade Message: Type=%c, DataLen=%d", streamName, source, msgType, len(data))
-
-	case 'C': // Trade Cancel Message
-		log.Printf("[%s-%s] Trade Cancel Message: Type=%c, DataLen=%d", streamName, source, msgType, len(data))
+		log.Printf("[%s-%s] Spread Trade Message: Token=%d, Price=%d, Qty=%d", streamName, source, m.Token, m.TradePrice, m.Quantity)
+		msg.Token, msg.Class = m.Token, ClassSpreadTrade
 
-	case 'Z': // Heartbeat Message
-		log.Printf("[%s-%s] Heartbeat Message", streamName, source)
+	case *TradeCancelMessage:
+		log.Printf("[%s-%s] Trade Cancel Message: Token=%d, Price=%d, Qty=%d", streamName, source, m.Token, m.TradePrice, m.TradeQty)
+		msg.Token, msg.Class = m.Token, ClassTradeCancel
 
-	default:
-		return fmt.Errorf("unknown message type: %c (0x%02X)", msgType, msgType)
+	case *HeartbeatMessage:
+		log.Printf("[%s-%s] Heartbeat Message: LastSeqNo=%d", streamName, source, m.LastSeqNo)
+		msg.Token, msg.Class = TokenWildcard, ClassHeartbeat
 	}
 
+	defaultPubSub.Publish(msg)
 	return nil
 }
 
@@ -208,41 +234,110 @@ func getStreamStats(streamName, source string) *StreamStats {
 	return streamStats[key]
 }
 
-// listenMulticast listens on a UDP multicast address
-func listenMulticast(stream FOStream, multicastIP string, port int, source string, wg *sync.WaitGroup) {
+// listenReadDeadline bounds each ReadFrom call so listenMulticast notices ctx
+// cancellation promptly instead of blocking on the socket indefinitely.
+const listenReadDeadline = 1 * time.Second
+
+// listenMulticast listens on a UDP multicast address until ctx is done.
+func listenMulticast(ctx context.Context, stream FOStream, multicastIP string, port int, source string, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	streamID := fmt.Sprintf("%s-%s", stream.StreamName, source)
 	log.Printf("[%s] Starting listener on %s:%d", streamID, multicastIP, port)
 
-	// Parse multicast address
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", multicastIP, port))
-	if err != nil {
-		log.Printf("[%s] Error resolving address: %v", streamID, err)
-		return
+	sourceCfg := receiverConfig.Source1
+	if source == "Source2" {
+		sourceCfg = receiverConfig.Source2
 	}
 
-	// Create UDP connection
-	conn, err := net.ListenMulticastUDP("udp", nil, addr)
+	var iface *net.Interface
+	if sourceCfg.Interface != "" {
+		var err error
+		iface, err = validateInterface(sourceCfg.Interface)
+		if err != nil {
+			log.Printf("[%s] Error validating interface %q: %v", streamID, sourceCfg.Interface, err)
+			return
+		}
+	}
+
+	// Bind to the port only; group membership (and, via SSM, the sender
+	// restriction) is established explicitly below rather than by
+	// net.ListenMulticastUDP picking whatever interface has a default
+	// route.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
 	if err != nil {
-		log.Printf("[%s] Error creating multicast listener: %v", streamID, err)
+		log.Printf("[%s] Error creating UDP listener: %v", streamID, err)
 		return
 	}
 	defer conn.Close()
 
+	pconn := ipv4.NewPacketConn(conn)
+	group := &net.UDPAddr{IP: net.ParseIP(multicastIP)}
+
+	if sourceCfg.SenderIP != "" {
+		sender := &net.UDPAddr{IP: net.ParseIP(sourceCfg.SenderIP)}
+		if err := pconn.JoinSourceSpecificGroup(iface, group, sender); err != nil {
+			log.Printf("[%s] Error joining source-specific group %s from %s on %s: %v", streamID, multicastIP, sourceCfg.SenderIP, sourceCfg.Interface, err)
+			return
+		}
+		log.Printf("[%s] Joined SSM group %s from source %s on interface %s", streamID, multicastIP, sourceCfg.SenderIP, sourceCfg.Interface)
+	} else {
+		if err := pconn.JoinGroup(iface, group); err != nil {
+			log.Printf("[%s] Error joining multicast group: %v", streamID, err)
+			return
+		}
+	}
+
+	if err := pconn.SetMulticastLoopback(false); err != nil {
+		log.Printf("[%s] Warning: could not disable multicast loopback: %v", streamID, err)
+	}
+	if err := pconn.SetControlMessage(ipv4.FlagInterface|ipv4.FlagTTL, true); err != nil {
+		log.Printf("[%s] Warning: could not enable control messages: %v", streamID, err)
+	}
+
 	// Set read buffer size (recommended in tuning guidelines)
 	if err := conn.SetReadBuffer(8 * 1024 * 1024); err != nil { // 8MB buffer
 		log.Printf("[%s] Warning: could not set read buffer: %v", streamID, err)
 	}
 
+	// Attach the in-kernel BPF prefilter, if configured, so unmatched
+	// packets never traverse the userspace copy or the Go scheduler.
+	applyFilter := func([]byte) bool { return true }
+	if !globalFilterOpts.empty() {
+		apply, err := AttachFilter(conn, globalFilterOpts)
+		if err != nil {
+			log.Printf("[%s] Warning: could not attach BPF filter, falling back to userspace: %v", streamID, err)
+			apply = globalFilterOpts.Matches
+		}
+		applyFilter = apply
+	}
+
 	log.Printf("[%s] Successfully listening on %s:%d", streamID, multicastIP, port)
 
 	buffer := make([]byte, 65536) // 64KB buffer for UDP packets
 	stats := getStreamStats(stream.StreamName, source)
 
+	gapRecovery := pipelineGapRecovery(stream)
+
 	for {
-		n, _, err := conn.ReadFromUDP(buffer)
+		select {
+		case <-ctx.Done():
+			log.Printf("[%s] Stopping listener: %v", streamID, ctx.Err())
+			return
+		default:
+		}
+
+		// A short read deadline keeps ReadFrom from blocking forever so the
+		// ctx.Done() check above is revisited regularly during shutdown.
+		if err := conn.SetReadDeadline(time.Now().Add(listenReadDeadline)); err != nil {
+			log.Printf("[%s] Warning: could not set read deadline: %v", streamID, err)
+		}
+
+		n, cm, src, err := pconn.ReadFrom(buffer)
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
 			stats.mu.Lock()
 			stats.ErrorCount++
 			stats.mu.Unlock()
@@ -250,10 +345,27 @@ func listenMulticast(stream FOStream, multicastIP string, port int, source strin
 			continue
 		}
 
+		if !applyFilter(buffer[:n]) {
+			continue
+		}
+
+		if captureWriter != nil {
+			if srcAddr, ok := src.(*net.UDPAddr); ok {
+				if err := captureWriter.WritePacket(time.Now(), srcAddr.IP, group.IP, srcAddr.Port, port, buffer[:n]); err != nil {
+					log.Printf("[%s] Error writing capture packet: %v", streamID, err)
+				}
+			}
+		}
+
 		// Update stats
 		stats.mu.Lock()
 		stats.PacketsReceived++
+		stats.BytesReceived += uint64(n)
 		stats.LastUpdate = time.Now()
+		if cm != nil {
+			stats.LastIfIndex = cm.IfIndex
+			stats.LastTTL = cm.TTL
+		}
 		stats.mu.Unlock()
 
 		// Parse header
@@ -267,13 +379,15 @@ func listenMulticast(stream FOStream, multicastIP string, port int, source strin
 		stats.LastSequence = header.SequenceNo
 		stats.mu.Unlock()
 
-		// Process message data (after 8-byte header)
+		// Own copy of the payload: the reorder queue inside GapRecovery may
+		// hold it past this iteration, after which buffer is reused.
+		payload := make([]byte, n-8)
 		if n > 8 {
-			if err := processMessage(buffer[8:n], stream.StreamName, source); err != nil {
-				log.Printf("[%s] Error processing message: %v", streamID, err)
-			}
+			copy(payload, buffer[8:n])
 		}
 
+		gapRecovery.Observe(header, payload, source)
+
 		// Log packet info (reduce frequency for high-volume streams)
 		if stats.PacketsReceived%100 == 0 {
 			log.Printf("[%s] Packets: %d, Seq: %d, Orders: %d, Trades: %d, Errors: %d",
@@ -293,24 +407,108 @@ func printStatistics() {
 		statsMutex.Lock()
 		for key, stats := range streamStats {
 			stats.mu.Lock()
-			log.Printf("[%s] Packets: %d | Seq: %d | Orders: %d | Trades: %d | Errors: %d | Last: %s",
-				key, stats.PacketsReceived, stats.LastSequence,
+			log.Printf("[%s] Packets: %d | Bytes: %d | Seq: %d | Orders: %d | Trades: %d | Errors: %d | IfIndex: %d | TTL: %d | Last: %s",
+				key, stats.PacketsReceived, stats.BytesReceived, stats.LastSequence,
 				stats.OrderCount, stats.TradeCount, stats.ErrorCount,
+				stats.LastIfIndex, stats.LastTTL,
 				time.Since(stats.LastUpdate).Round(time.Second))
 			stats.mu.Unlock()
 		}
 		statsMutex.Unlock()
+
+		arbitratorsMutex.Lock()
+		for streamID, arbitrator := range arbitrators {
+			dedup, lateDrops, merged := arbitrator.Stats()
+			log.Printf("[StreamID=%d] MergedSeq: %d | DedupCount: %d | LateDrops: %d",
+				streamID, merged, dedup, lateDrops)
+		}
+		arbitratorsMutex.Unlock()
+
+		gapRecoveriesMutex.Lock()
+		for streamID, gapRecovery := range gapRecoveries {
+			gapsDetected, gapsAbandoned := gapRecovery.Stats()
+			log.Printf("[StreamID=%d] GapsDetected: %d | GapsAbandoned: %d", streamID, gapsDetected, gapsAbandoned)
+		}
+		gapRecoveriesMutex.Unlock()
+		log.Printf("SlowConsumerDrops: %d", atomic.LoadUint64(&SlowConsumerDrops))
 		log.Println("=============================================")
 	}
 }
 
+// captureWriter, when non-nil (via -capture), receives a copy of every
+// packet listenMulticast reads before it enters the processing pipeline.
+var captureWriter *CaptureWriter
+
+// shutdownTimeout bounds how long main waits for all listener goroutines to
+// exit after ctx is cancelled before giving up and exiting anyway.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	capturePath := flag.String("capture", "", "write every received multicast packet to this pcap file")
+	replayPath := flag.String("replay", "", "read multicast packets from this pcap file instead of joining live groups")
+	speed := flag.Float64("speed", 1.0, "replay speed multiplier (2.0 = twice as fast, 0 = as fast as possible); only used with -replay")
+	httpAddr := flag.String("http-addr", ":9090", "address to serve /metrics and /healthz on")
+	enablePprof := flag.Bool("pprof", false, "expose net/http/pprof endpoints under /debug/pprof/ on the observability server")
+	flag.DurationVar(&maxSilence, "max-silence", maxSilence, "how long a stream may go without a packet before /healthz reports unhealthy")
+	bpfMessageTypes := flag.String("bpf-message-types", "", "comma-separated message type bytes (e.g. \"N,M,X,T\") to accept via the in-kernel BPF prefilter; empty accepts all")
+	bpfStreamIDs := flag.String("bpf-stream-ids", "", "comma-separated StreamIDs to accept via the in-kernel BPF prefilter; empty accepts all")
+	source1Iface := flag.String("source1-iface", "", "network interface to join Source1 multicast groups on (e.g. eth0); required for an SSM join")
+	source1SenderIP := flag.String("source1-sender-ip", "", "exchange sender IP for IGMPv3 SSM join on Source1 feeds; requires -source1-iface")
+	source2Iface := flag.String("source2-iface", "", "network interface to join Source2 multicast groups on (e.g. eth1); required for an SSM join")
+	source2SenderIP := flag.String("source2-sender-ip", "", "exchange sender IP for IGMPv3 SSM join on Source2 feeds; requires -source2-iface")
+	flag.Parse()
+
+	filterOpts, err := ParseFilterOpts(*bpfMessageTypes, *bpfStreamIDs)
+	if err != nil {
+		log.Fatalf("Invalid BPF filter flags: %v", err)
+	}
+	globalFilterOpts = filterOpts
+
+	receiverConfig = ReceiverConfig{
+		Source1: SourceConfig{Interface: *source1Iface, SenderIP: *source1SenderIP},
+		Source2: SourceConfig{Interface: *source2Iface, SenderIP: *source2SenderIP},
+	}
+	if err := receiverConfig.Source1.Validate(); err != nil {
+		log.Fatalf("Invalid Source1 network config: %v", err)
+	}
+	if err := receiverConfig.Source2.Validate(); err != nil {
+		log.Fatalf("Invalid Source2 network config: %v", err)
+	}
+
+	srv := StartObservabilityServer(*httpAddr, *enablePprof)
+	log.Printf("Observability server listening on %s (/metrics, /healthz)", *httpAddr)
+
+	if *replayPath != "" {
+		log.Println("========================================")
+		log.Println("NSE MTBT Receiver - Replay Mode")
+		log.Println("========================================")
+		StartBookBuilder()
+		if err := RunReplay(*replayPath, *speed); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		shutdownObservabilityServer(srv)
+		return
+	}
+
 	log.Println("========================================")
 	log.Println("NSE MTBT (Multicast Tick-by-Tick) Receiver")
 	log.Println("FO Segment - All 36 Connections")
 	log.Println("========================================")
 
+	if *capturePath != "" {
+		writer, err := NewCaptureWriter(*capturePath)
+		if err != nil {
+			log.Fatalf("Error opening capture file: %v", err)
+		}
+		defer writer.Close()
+		captureWriter = writer
+		log.Printf("Capturing every received packet to %s", *capturePath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	var wg sync.WaitGroup
 
 	// Launch goroutines for all 36 connections (18 streams x 2 sources)
@@ -319,12 +517,12 @@ func main() {
 		// Source 1
 		wg.Add(1)
 		totalConnections++
-		go listenMulticast(stream, stream.Source1IP, stream.Source1Port, "Source1", &wg)
+		go listenMulticast(ctx, stream, stream.Source1IP, stream.Source1Port, "Source1", &wg)
 
 		// Source 2 (Delayed Feed)
 		wg.Add(1)
 		totalConnections++
-		go listenMulticast(stream, stream.Source2IP, stream.Source2Port, "Source2", &wg)
+		go listenMulticast(ctx, stream, stream.Source2IP, stream.Source2Port, "Source2", &wg)
 
 		// Small delay to avoid overwhelming system
 		time.Sleep(10 * time.Millisecond)
@@ -333,6 +531,9 @@ func main() {
 	log.Printf("Launched %d goroutines for %d FO streams (Source1 + Source2)", totalConnections, len(foStreams))
 	log.Println("Note: You need proper network access and permissions to receive NSE market data")
 
+	// Drive order book reconstruction off the same decoded message stream.
+	StartBookBuilder()
+
 	// Start statistics printer
 	go printStatistics()
 
@@ -343,7 +544,22 @@ func main() {
 	log.Println("MTBT Receiver is running. Press Ctrl+C to stop.")
 	<-sigChan
 
-	log.Println("\nShutdown signal received. Stopping all listeners...")
-	// Note: In production, implement graceful shutdown with context cancellation
+	log.Println("Shutdown signal received. Stopping all listeners...")
+	cancel()
+
+	listenersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(listenersDone)
+	}()
+
+	select {
+	case <-listenersDone:
+		log.Println("All listeners stopped cleanly.")
+	case <-time.After(shutdownTimeout):
+		log.Printf("Timed out after %s waiting for listeners to stop; exiting anyway.", shutdownTimeout)
+	}
+
+	shutdownObservabilityServer(srv)
 	log.Println("Goodbye!")
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// SourceConfig pins a feed source (Source1 or Source2) to a specific NIC and,
+// for an IGMPv3 source-specific join, the exchange's known sender IP for
+// that feed. Left at its zero value, listenMulticast falls back to an
+// any-source join on the kernel's default-route interface — the same
+// behavior as before this was added, just explicit about it.
+type SourceConfig struct {
+	// Interface names the NIC to join the multicast group on, e.g. "eth1".
+	// Required on multi-NIC colo hosts where Source1/Source2 A/B feeds
+	// arrive on separate physical interfaces and the kernel's default route
+	// would otherwise pick one for both.
+	Interface string
+
+	// SenderIP is the exchange's known source IP for this feed. When set,
+	// the join uses IGMPv3 source-specific multicast (SSM) restricted to
+	// that sender; Interface must also be set.
+	SenderIP string
+}
+
+// Validate fails fast when SenderIP is set without Interface: listenMulticast
+// would otherwise pass a nil interface to JoinSourceSpecificGroup, which
+// silently falls back to the kernel's default-route interface instead of
+// the one the caller presumably intended to pin the SSM join to.
+func (c SourceConfig) Validate() error {
+	if c.SenderIP != "" && c.Interface == "" {
+		return fmt.Errorf("sender IP %q set without an interface: SSM join requires both", c.SenderIP)
+	}
+	return nil
+}
+
+// ReceiverConfig configures per-source interface/SSM settings independently
+// for Source1 and Source2.
+type ReceiverConfig struct {
+	Source1 SourceConfig
+	Source2 SourceConfig
+}
+
+// receiverConfig is the process-wide interface/SSM configuration consulted
+// by listenMulticast. Left at its zero value (both SourceConfigs empty)
+// preserves the original any-source, kernel-chosen-interface behavior.
+var receiverConfig ReceiverConfig
+
+// validateInterface confirms name refers to an interface that is up, has an
+// IPv4 address, and supports multicast, failing fast with a clear error
+// rather than silently receiving on the wrong link.
+func validateInterface(name string) (*net.Interface, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q not found: %w", name, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %q is not up", name)
+	}
+	if iface.Flags&net.FlagMulticast == 0 {
+		return nil, fmt.Errorf("interface %q does not support multicast", name)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("listing addresses for interface %q: %w", name, err)
+	}
+	hasIPv4 := false
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() != nil {
+			hasIPv4 = true
+			break
+		}
+	}
+	if !hasIPv4 {
+		return nil, fmt.Errorf("interface %q has no IPv4 address", name)
+	}
+
+	return iface, nil
+}
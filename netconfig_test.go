@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestValidateInterfaceUnknown(t *testing.T) {
+	if _, err := validateInterface("definitely-not-a-real-interface-0"); err == nil {
+		t.Fatal("expected an error for a nonexistent interface")
+	}
+}
+
+func TestSourceConfigValidateRequiresInterfaceForSenderIP(t *testing.T) {
+	cfg := SourceConfig{SenderIP: "10.0.0.1"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for a SenderIP set without an Interface")
+	}
+}
+
+func TestSourceConfigValidateAcceptsSenderIPWithInterface(t *testing.T) {
+	cfg := SourceConfig{Interface: "eth0", SenderIP: "10.0.0.1"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSourceConfigValidateAcceptsZeroValue(t *testing.T) {
+	if err := (SourceConfig{}).Validate(); err != nil {
+		t.Fatalf("unexpected error for zero-value config: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxSilence is how long a stream may go without a packet before /healthz
+// reports unhealthy. NSE sends heartbeats on otherwise-quiet streams, so
+// silence past this window means loss, not a quiet market. Overridable via
+// -max-silence.
+var maxSilence = 15 * time.Second
+
+// StartObservabilityServer starts an HTTP server on addr exposing /metrics in
+// Prometheus text format and /healthz, and, if enablePprof is set, the
+// net/http/pprof endpoints under /debug/pprof/ for capturing goroutine and
+// CPU profiles during production incidents. It returns immediately; callers
+// are responsible for shutting the server down (see shutdownObservabilityServer).
+func StartObservabilityServer(addr string, enablePprof bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Observability server error: %v", err)
+		}
+	}()
+	return srv
+}
+
+// shutdownObservabilityServer gracefully stops srv, bounded so a stuck
+// connection can't hang process exit indefinitely.
+func shutdownObservabilityServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down observability server: %v", err)
+	}
+}
+
+// streamStatsSnapshot is a point-in-time copy of one StreamStats, taken so
+// metricsHandler doesn't hold statsMutex or a per-stream mutex while writing
+// to the response.
+type streamStatsSnapshot struct {
+	key     string
+	packets uint64
+	bytes   uint64
+	lastSeq uint32
+	errors  uint64
+	age     time.Duration
+}
+
+func snapshotStreamStats() []streamStatsSnapshot {
+	statsMutex.Lock()
+	keys := make([]string, 0, len(streamStats))
+	for key := range streamStats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	snapshots := make([]streamStatsSnapshot, 0, len(keys))
+	for _, key := range keys {
+		s := streamStats[key]
+		s.mu.Lock()
+		snapshots = append(snapshots, streamStatsSnapshot{
+			key:     key,
+			packets: s.PacketsReceived,
+			bytes:   s.BytesReceived,
+			lastSeq: s.LastSequence,
+			errors:  s.ErrorCount,
+			age:     time.Since(s.LastUpdate),
+		})
+		s.mu.Unlock()
+	}
+	statsMutex.Unlock()
+	return snapshots
+}
+
+// metricsHandler serves process-wide and per-stream counters in Prometheus
+// text exposition format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	snapshots := snapshotStreamStats()
+
+	fmt.Fprintln(w, "# HELP mtbt_packets_received_total Total packets received per stream/source.")
+	fmt.Fprintln(w, "# TYPE mtbt_packets_received_total counter")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "mtbt_packets_received_total{stream=%q} %d\n", s.key, s.packets)
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_bytes_received_total Total bytes received per stream/source.")
+	fmt.Fprintln(w, "# TYPE mtbt_bytes_received_total counter")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "mtbt_bytes_received_total{stream=%q} %d\n", s.key, s.bytes)
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_errors_total Total read/parse errors per stream/source.")
+	fmt.Fprintln(w, "# TYPE mtbt_errors_total counter")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "mtbt_errors_total{stream=%q} %d\n", s.key, s.errors)
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_last_sequence Last sequence number observed per stream/source.")
+	fmt.Fprintln(w, "# TYPE mtbt_last_sequence gauge")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "mtbt_last_sequence{stream=%q} %d\n", s.key, s.lastSeq)
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_last_update_age_seconds Seconds since the last packet was received per stream/source.")
+	fmt.Fprintln(w, "# TYPE mtbt_last_update_age_seconds gauge")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "mtbt_last_update_age_seconds{stream=%q} %.3f\n", s.key, s.age.Seconds())
+	}
+
+	arbitratorsMutex.Lock()
+	streamIDs := make([]int, 0, len(arbitrators))
+	for id := range arbitrators {
+		streamIDs = append(streamIDs, id)
+	}
+	sort.Ints(streamIDs)
+	dedupByStream := make(map[int]uint64, len(streamIDs))
+	lateDropsByStream := make(map[int]uint64, len(streamIDs))
+	for _, id := range streamIDs {
+		dedup, lateDrops, _ := arbitrators[id].Stats()
+		dedupByStream[id] = dedup
+		lateDropsByStream[id] = lateDrops
+	}
+	arbitratorsMutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP mtbt_dedup_total Packets dropped as A/B duplicates per StreamID.")
+	fmt.Fprintln(w, "# TYPE mtbt_dedup_total counter")
+	for _, id := range streamIDs {
+		fmt.Fprintf(w, "mtbt_dedup_total{stream_id=\"%d\"} %d\n", id, dedupByStream[id])
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_late_drops_total Packets dropped as unrecoverably late per StreamID.")
+	fmt.Fprintln(w, "# TYPE mtbt_late_drops_total counter")
+	for _, id := range streamIDs {
+		fmt.Fprintf(w, "mtbt_late_drops_total{stream_id=\"%d\"} %d\n", id, lateDropsByStream[id])
+	}
+
+	gapRecoveriesMutex.Lock()
+	gapIDs := make([]int, 0, len(gapRecoveries))
+	for id := range gapRecoveries {
+		gapIDs = append(gapIDs, id)
+	}
+	sort.Ints(gapIDs)
+	gapsDetectedByStream := make(map[int]uint64, len(gapIDs))
+	gapsAbandonedByStream := make(map[int]uint64, len(gapIDs))
+	for _, id := range gapIDs {
+		gapsDetectedByStream[id], gapsAbandonedByStream[id] = gapRecoveries[id].Stats()
+	}
+	gapRecoveriesMutex.Unlock()
+
+	fmt.Fprintln(w, "# HELP mtbt_gaps_detected_total Sequence gaps detected per StreamID.")
+	fmt.Fprintln(w, "# TYPE mtbt_gaps_detected_total counter")
+	for _, id := range gapIDs {
+		fmt.Fprintf(w, "mtbt_gaps_detected_total{stream_id=\"%d\"} %d\n", id, gapsDetectedByStream[id])
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_gaps_abandoned_total Sequence gaps given up on (never filled by reorder, the other source, or recovery) per StreamID.")
+	fmt.Fprintln(w, "# TYPE mtbt_gaps_abandoned_total counter")
+	for _, id := range gapIDs {
+		fmt.Fprintf(w, "mtbt_gaps_abandoned_total{stream_id=\"%d\"} %d\n", id, gapsAbandonedByStream[id])
+	}
+
+	fmt.Fprintln(w, "# HELP mtbt_slow_consumer_drops_total Messages dropped because a pub/sub subscriber fell behind.")
+	fmt.Fprintln(w, "# TYPE mtbt_slow_consumer_drops_total counter")
+	fmt.Fprintf(w, "mtbt_slow_consumer_drops_total %d\n", atomic.LoadUint64(&SlowConsumerDrops))
+}
+
+// healthzHandler reports unhealthy (503) when any stream has gone silent
+// longer than maxSilence, since NSE sends heartbeats on otherwise-quiet
+// streams and silence past that window means the feed has been lost rather
+// than that the market is quiet.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	var stale []string
+	for _, s := range snapshotStreamStats() {
+		if s.age > maxSilence {
+			stale = append(stale, fmt.Sprintf("%s (silent %s)", s.key, s.age.Round(time.Second)))
+		}
+	}
+
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "unhealthy: %d stream(s) silent past %s: %s\n", len(stale), maxSilence, strings.Join(stale, ", "))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withFreshStreamStats swaps in an empty streamStats map for the duration of
+// t, restoring the original afterwards, so healthzHandler (which considers
+// every stream currently tracked) doesn't see entries left behind by other
+// tests in this package.
+func withFreshStreamStats(t *testing.T) {
+	t.Helper()
+	statsMutex.Lock()
+	original := streamStats
+	streamStats = make(map[string]*StreamStats)
+	statsMutex.Unlock()
+
+	t.Cleanup(func() {
+		statsMutex.Lock()
+		streamStats = original
+		statsMutex.Unlock()
+	})
+}
+
+func TestHealthzHandlerReportsUnhealthyOnStaleStream(t *testing.T) {
+	withFreshStreamStats(t)
+	stats := getStreamStats("TEST_HEALTHZ_STALE", "Source1")
+	stats.mu.Lock()
+	stats.LastUpdate = time.Now().Add(-2 * maxSilence)
+	stats.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	healthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != 503 {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "TEST_HEALTHZ_STALE-Source1") {
+		t.Fatalf("expected body to name the stale stream, got %q", rec.Body.String())
+	}
+}
+
+func TestHealthzHandlerReportsHealthyWhenAllStreamsFresh(t *testing.T) {
+	withFreshStreamStats(t)
+	stats := getStreamStats("TEST_HEALTHZ_FRESH", "Source1")
+	stats.mu.Lock()
+	stats.LastUpdate = time.Now()
+	stats.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	healthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != "ok\n" {
+		t.Fatalf("unexpected body: %q", rec.Body.String())
+	}
+}
+
+func TestMetricsHandlerEmitsStreamAndCounterLines(t *testing.T) {
+	withFreshStreamStats(t)
+	stats := getStreamStats("TEST_METRICS", "Source1")
+	stats.mu.Lock()
+	stats.PacketsReceived = 42
+	stats.BytesReceived = 1024
+	stats.LastSequence = 7
+	stats.ErrorCount = 3
+	stats.LastUpdate = time.Now()
+	stats.mu.Unlock()
+
+	arb := getArbitrator(987654)
+	arb.Arbitrate(1)
+	arb.Arbitrate(1) // duplicate
+	arb.Arbitrate(arbitratorWindowSize)
+	arb.Arbitrate(0) // now past the low-water mark: late drop
+
+	gr := getGapRecovery(FOStream{StreamID: 987654}, "", nil, nil)
+	gr.mu.Lock()
+	gr.gapsDetected = 5
+	gr.gapsAbandoned = 2
+	gr.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`mtbt_packets_received_total{stream="TEST_METRICS-Source1"} 42`,
+		`mtbt_bytes_received_total{stream="TEST_METRICS-Source1"} 1024`,
+		`mtbt_errors_total{stream="TEST_METRICS-Source1"} 3`,
+		`mtbt_last_sequence{stream="TEST_METRICS-Source1"} 7`,
+		`mtbt_dedup_total{stream_id="987654"} 1`,
+		`mtbt_late_drops_total{stream_id="987654"} 1`,
+		`mtbt_gaps_detected_total{stream_id="987654"} 5`,
+		`mtbt_gaps_abandoned_total{stream_id="987654"} 2`,
+		`mtbt_slow_consumer_drops_total`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected metrics output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
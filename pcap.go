@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// pcap file format constants (https://wiki.wireshark.org/Development/LibpcapFileFormat).
+const (
+	pcapMagicMicroseconds = 0xa1b2c3d4
+	pcapVersionMajor      = 2
+	pcapVersionMinor      = 4
+	pcapSnapLen           = 65535
+
+	// linkTypeRaw is LINKTYPE_RAW: the captured frame is a bare IPv4/IPv6
+	// packet with no link-layer header, which is all we need since we
+	// synthesize the IP/UDP headers ourselves rather than capturing them
+	// off the wire.
+	linkTypeRaw = 101
+)
+
+// pcapGlobalHeader is the 24-byte header at the start of every pcap file.
+type pcapGlobalHeader struct {
+	MagicNumber  uint32
+	VersionMajor uint16
+	VersionMinor uint16
+	ThisZone     int32
+	SigFigs      uint32
+	SnapLen      uint32
+	Network      uint32
+}
+
+// pcapPacketHeader precedes every captured frame.
+type pcapPacketHeader struct {
+	TsSec   uint32
+	TsUsec  uint32
+	InclLen uint32
+	OrigLen uint32
+}
+
+// synthesizeIPv4UDP builds a minimal IPv4 + UDP datagram carrying payload,
+// so replay can recover source/destination IP and port without needing a
+// real link-layer capture.
+func synthesizeIPv4UDP(srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte) ([]byte, error) {
+	src4, dst4 := srcIP.To4(), dstIP.To4()
+	if src4 == nil || dst4 == nil {
+		return nil, fmt.Errorf("synthesizing IPv4 header: srcIP=%s dstIP=%s must be IPv4", srcIP, dstIP)
+	}
+
+	udpLen := 8 + len(payload)
+	totalLen := 20 + udpLen
+	frame := make([]byte, totalLen)
+
+	// IPv4 header.
+	frame[0] = 0x45 // version 4, IHL 5 (20 bytes, no options)
+	frame[1] = 0
+	binary.BigEndian.PutUint16(frame[2:4], uint16(totalLen))
+	binary.BigEndian.PutUint16(frame[4:6], 0) // identification
+	binary.BigEndian.PutUint16(frame[6:8], 0) // flags/fragment offset
+	frame[8] = 64                             // TTL
+	frame[9] = 17                             // protocol: UDP
+	binary.BigEndian.PutUint16(frame[10:12], 0)
+	copy(frame[12:16], src4)
+	copy(frame[16:20], dst4)
+	binary.BigEndian.PutUint16(frame[10:12], ipv4Checksum(frame[0:20]))
+
+	// UDP header.
+	binary.BigEndian.PutUint16(frame[20:22], uint16(srcPort))
+	binary.BigEndian.PutUint16(frame[22:24], uint16(dstPort))
+	binary.BigEndian.PutUint16(frame[24:26], uint16(udpLen))
+	binary.BigEndian.PutUint16(frame[26:28], 0) // checksum: optional for IPv4, left unset
+
+	copy(frame[28:], payload)
+	return frame, nil
+}
+
+// ipv4Checksum computes the standard one's-complement IPv4 header checksum.
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(header[i])<<8 | uint32(header[i+1])
+	}
+	if len(header)%2 == 1 {
+		sum += uint32(header[len(header)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// parseIPv4UDP extracts the source/destination IP:port and UDP payload from
+// a synthesized (or real) IPv4/UDP frame as written by synthesizeIPv4UDP.
+func parseIPv4UDP(frame []byte) (srcIP, dstIP net.IP, srcPort, dstPort int, payload []byte, err error) {
+	if len(frame) < 20 {
+		return nil, nil, 0, 0, nil, fmt.Errorf("frame too short for an IPv4 header: %d bytes", len(frame))
+	}
+	ihl := int(frame[0]&0x0f) * 4
+	if ihl < 20 || len(frame) < ihl+8 {
+		return nil, nil, 0, 0, nil, fmt.Errorf("frame too short for IHL=%d plus a UDP header: %d bytes", ihl, len(frame))
+	}
+	if frame[9] != 17 {
+		return nil, nil, 0, 0, nil, fmt.Errorf("unexpected IP protocol %d, want UDP(17)", frame[9])
+	}
+
+	srcIP = net.IP(append([]byte(nil), frame[12:16]...))
+	dstIP = net.IP(append([]byte(nil), frame[16:20]...))
+	udp := frame[ihl:]
+	srcPort = int(binary.BigEndian.Uint16(udp[0:2]))
+	dstPort = int(binary.BigEndian.Uint16(udp[2:4]))
+	payload = append([]byte(nil), udp[8:]...)
+	return srcIP, dstIP, srcPort, dstPort, payload, nil
+}
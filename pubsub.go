@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MessageClass groups decoded messages the way Filter selects on them.
+type MessageClass int
+
+const (
+	ClassOrder MessageClass = iota
+	ClassTrade
+	ClassSpreadOrder
+	ClassSpreadTrade
+	ClassTradeCancel
+	ClassHeartbeat
+)
+
+// TokenWildcard, used as Filter.Token, matches every token.
+const TokenWildcard int32 = -1
+
+// Msg is what subscribers receive: a decoded message plus the stream
+// metadata needed to route and interpret it.
+type Msg struct {
+	StreamID   int
+	StreamName string
+	Source     string
+	Token      int32
+	Class      MessageClass
+	Payload    interface{} // *OrderMessage, *TradeMessage, *SpreadOrderMessage, *SpreadTradeMessage, *TradeCancelMessage, or *HeartbeatMessage
+}
+
+// Filter selects which published messages a subscription receives. A zero
+// value StreamID or a nil Classes matches any stream/class; Token must be
+// set explicitly to TokenWildcard to match any token (heartbeats carry no
+// token, so an empty Classes with Token set still excludes them unless
+// ClassHeartbeat is listed).
+type Filter struct {
+	Token    int32
+	StreamID int
+	Classes  []MessageClass
+}
+
+func (f Filter) matchesStream(streamID int) bool {
+	return f.StreamID == 0 || f.StreamID == streamID
+}
+
+func (f Filter) matchesClass(class MessageClass) bool {
+	if len(f.Classes) == 0 {
+		return true
+	}
+	for _, c := range f.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionChanSize is the bounded channel depth per subscription. A slow
+// consumer fills this before messages start dropping rather than blocking
+// the multicast reader.
+const subscriptionChanSize = 1024
+
+// subscription is one registered Subscribe call.
+type subscription struct {
+	id     uint64
+	filter Filter
+	ch     chan Msg
+	drops  uint64
+}
+
+// SlowConsumerDrops counts messages dropped across all subscriptions because
+// a handler fell behind and its channel was full.
+var SlowConsumerDrops uint64
+
+// PubSub dispatches decoded messages to subscribers, keyed by Token with a
+// wildcard fallback bucket, decoupling ingest from user logic.
+type PubSub struct {
+	mu       sync.RWMutex
+	nextID   uint64
+	byToken  map[int32][]*subscription
+	wildcard []*subscription
+}
+
+// NewPubSub creates an empty PubSub.
+func NewPubSub() *PubSub {
+	return &PubSub{byToken: make(map[int32][]*subscription)}
+}
+
+// defaultPubSub is the process-wide dispatcher fed by processMessage.
+var defaultPubSub = NewPubSub()
+
+// Subscribe registers handler to be invoked for every published Msg matching
+// filter. handler runs on a dedicated goroutine per subscription, fed by a
+// bounded channel; if handler falls behind, messages are dropped and counted
+// in SlowConsumerDrops rather than blocking the publisher. The returned
+// function unregisters the subscription and stops its goroutine.
+func (p *PubSub) Subscribe(filter Filter, handler func(Msg)) (unsubscribe func()) {
+	sub := &subscription{
+		filter: filter,
+		ch:     make(chan Msg, subscriptionChanSize),
+	}
+
+	p.mu.Lock()
+	p.nextID++
+	sub.id = p.nextID
+	if filter.Token == TokenWildcard {
+		p.wildcard = append(p.wildcard, sub)
+	} else {
+		p.byToken[filter.Token] = append(p.byToken[filter.Token], sub)
+	}
+	p.mu.Unlock()
+
+	go func() {
+		for msg := range sub.ch {
+			handler(msg)
+		}
+	}()
+
+	return func() { p.unsubscribe(sub) }
+}
+
+func (p *PubSub) unsubscribe(sub *subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sub.filter.Token == TokenWildcard {
+		p.wildcard = removeSubscription(p.wildcard, sub)
+	} else {
+		p.byToken[sub.filter.Token] = removeSubscription(p.byToken[sub.filter.Token], sub)
+	}
+	close(sub.ch)
+}
+
+func removeSubscription(subs []*subscription, target *subscription) []*subscription {
+	for i, s := range subs {
+		if s == target {
+			return append(subs[:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// Publish delivers msg to every subscription whose filter matches. Delivery
+// is non-blocking: a full subscription channel drops the message and
+// increments SlowConsumerDrops rather than stalling the caller (the
+// multicast reader).
+//
+// The RLock is held for the whole send loop, not just the subscriber lookup:
+// unsubscribe takes the write lock to close a subscription's channel, so
+// holding the read lock through the send makes "send to sub.ch" and "close
+// sub.ch" mutually exclusive and a send can never race a close.
+func (p *PubSub) Publish(msg Msg) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	subs := make([]*subscription, 0, len(p.wildcard)+len(p.byToken[msg.Token]))
+	subs = append(subs, p.wildcard...)
+	subs = append(subs, p.byToken[msg.Token]...)
+
+	for _, sub := range subs {
+		if !sub.filter.matchesStream(msg.StreamID) || !sub.filter.matchesClass(msg.Class) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			atomic.AddUint64(&sub.drops, 1)
+			atomic.AddUint64(&SlowConsumerDrops, 1)
+		}
+	}
+}
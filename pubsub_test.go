@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPubSubDeliversToMatchingToken(t *testing.T) {
+	p := NewPubSub()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var got Msg
+	unsubscribe := p.Subscribe(Filter{Token: 101}, func(msg Msg) {
+		got = msg
+		wg.Done()
+	})
+	defer unsubscribe()
+
+	p.Publish(Msg{StreamID: 1, Token: 101, Class: ClassTrade})
+
+	if !waitTimeout(&wg, time.Second) {
+		t.Fatal("handler was not invoked")
+	}
+	if got.Token != 101 || got.Class != ClassTrade {
+		t.Fatalf("unexpected message delivered: %+v", got)
+	}
+}
+
+func TestPubSubIgnoresNonMatchingToken(t *testing.T) {
+	p := NewPubSub()
+
+	called := false
+	unsubscribe := p.Subscribe(Filter{Token: 101}, func(msg Msg) { called = true })
+	defer unsubscribe()
+
+	p.Publish(Msg{StreamID: 1, Token: 202, Class: ClassTrade})
+	time.Sleep(20 * time.Millisecond)
+
+	if called {
+		t.Fatal("handler should not have been invoked for a non-matching token")
+	}
+}
+
+func TestPubSubWildcardReceivesAllTokens(t *testing.T) {
+	p := NewPubSub()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	unsubscribe := p.Subscribe(Filter{Token: TokenWildcard}, func(msg Msg) { wg.Done() })
+	defer unsubscribe()
+
+	p.Publish(Msg{StreamID: 1, Token: 1, Class: ClassOrder})
+	p.Publish(Msg{StreamID: 1, Token: 2, Class: ClassOrder})
+
+	if !waitTimeout(&wg, time.Second) {
+		t.Fatal("wildcard subscription did not receive both messages")
+	}
+}
+
+func TestPubSubFilterByClassAndStream(t *testing.T) {
+	p := NewPubSub()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	unsubscribe := p.Subscribe(Filter{Token: TokenWildcard, StreamID: 1, Classes: []MessageClass{ClassTrade}}, func(msg Msg) {
+		wg.Done()
+	})
+	defer unsubscribe()
+
+	p.Publish(Msg{StreamID: 2, Token: 1, Class: ClassTrade}) // wrong stream
+	p.Publish(Msg{StreamID: 1, Token: 1, Class: ClassOrder}) // wrong class
+	p.Publish(Msg{StreamID: 1, Token: 1, Class: ClassTrade}) // matches
+
+	if !waitTimeout(&wg, time.Second) {
+		t.Fatal("expected exactly the matching message to be delivered")
+	}
+}
+
+func TestPubSubSlowConsumerDrops(t *testing.T) {
+	p := NewPubSub()
+	before := SlowConsumerDrops
+
+	block := make(chan struct{})
+	unsubscribe := p.Subscribe(Filter{Token: TokenWildcard}, func(msg Msg) { <-block })
+	defer func() {
+		close(block)
+		unsubscribe()
+	}()
+
+	for i := 0; i < subscriptionChanSize+10; i++ {
+		p.Publish(Msg{StreamID: 1, Token: 1, Class: ClassOrder})
+	}
+
+	if SlowConsumerDrops <= before {
+		t.Fatalf("expected SlowConsumerDrops to increase, before=%d after=%d", before, SlowConsumerDrops)
+	}
+}
+
+// TestPubSubConcurrentPublishAndUnsubscribeDoesNotPanic guards against
+// Publish sending on a channel unsubscribe has already closed: unsubscribe
+// takes the write lock to remove and close a subscription, so it must not
+// be able to interleave with a Publish call that already looked that
+// subscription up and is mid-send.
+func TestPubSubConcurrentPublishAndUnsubscribeDoesNotPanic(t *testing.T) {
+	p := NewPubSub()
+
+	for i := 0; i < 200; i++ {
+		unsubscribe := p.Subscribe(Filter{Token: TokenWildcard}, func(msg Msg) {})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			p.Publish(Msg{StreamID: 1, Token: 1, Class: ClassOrder})
+		}()
+		go func() {
+			defer wg.Done()
+			unsubscribe()
+		}()
+		wg.Wait()
+	}
+}
+
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// ReplayPacket is one frame read back from a pcap capture, with the
+// synthesized IPv4/UDP headers already stripped off.
+type ReplayPacket struct {
+	Timestamp time.Time
+	SrcIP     net.IP
+	DstIP     net.IP
+	SrcPort   int
+	DstPort   int
+	Payload   []byte // StreamHeader + message body, as originally received
+}
+
+// ReplayReader reads frames back out of a pcap file written by CaptureWriter.
+type ReplayReader struct {
+	file *os.File
+}
+
+// NewReplayReader opens path and validates the pcap global header.
+func NewReplayReader(path string) (*ReplayReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening replay file %s: %w", path, err)
+	}
+
+	var hdr pcapGlobalHeader
+	if err := binary.Read(file, binary.LittleEndian, &hdr); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading pcap global header: %w", err)
+	}
+	if hdr.MagicNumber != pcapMagicMicroseconds {
+		file.Close()
+		return nil, fmt.Errorf("unsupported pcap magic number: 0x%x", hdr.MagicNumber)
+	}
+
+	return &ReplayReader{file: file}, nil
+}
+
+// Next returns the next packet in the capture, or io.EOF once exhausted.
+func (r *ReplayReader) Next() (*ReplayPacket, error) {
+	var hdr pcapPacketHeader
+	if err := binary.Read(r.file, binary.LittleEndian, &hdr); err != nil {
+		return nil, err // propagates io.EOF cleanly at end of file
+	}
+
+	frame := make([]byte, hdr.InclLen)
+	if _, err := io.ReadFull(r.file, frame); err != nil {
+		return nil, fmt.Errorf("reading pcap frame: %w", err)
+	}
+
+	srcIP, dstIP, srcPort, dstPort, payload, err := parseIPv4UDP(frame)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pcap frame: %w", err)
+	}
+
+	return &ReplayPacket{
+		Timestamp: time.Unix(int64(hdr.TsSec), int64(hdr.TsUsec)*1000),
+		SrcIP:     srcIP,
+		DstIP:     dstIP,
+		SrcPort:   srcPort,
+		DstPort:   dstPort,
+		Payload:   payload,
+	}, nil
+}
+
+// Close closes the underlying pcap file.
+func (r *ReplayReader) Close() error {
+	return r.file.Close()
+}
+
+// findStreamBySocket looks up which FOStream/source a captured multicast
+// destination IP:port belongs to, so replay can feed each packet into the
+// same per-stream pipeline live packets use.
+func findStreamBySocket(dstIP net.IP, dstPort int) (FOStream, string, bool) {
+	for _, stream := range foStreams {
+		if dstPort == stream.Source1Port && dstIP.Equal(net.ParseIP(stream.Source1IP)) {
+			return stream, "Source1", true
+		}
+		if dstPort == stream.Source2Port && dstIP.Equal(net.ParseIP(stream.Source2IP)) {
+			return stream, "Source2", true
+		}
+	}
+	return FOStream{}, "", false
+}
+
+// RunReplay reads a pcap capture written by -capture and feeds its packets
+// into the same parseStreamHeader / processMessage pipeline live multicast
+// traffic uses, honoring the capture's recorded inter-arrival times scaled
+// by speed (2.0 replays twice as fast, 0 replays as fast as possible).
+func RunReplay(path string, speed float64) error {
+	reader, err := NewReplayReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	log.Printf("Replay: reading %s at %gx speed", path, speed)
+
+	var lastTimestamp time.Time
+	delivered, skipped := 0, 0
+	for {
+		pkt, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if speed > 0 && !lastTimestamp.IsZero() {
+			if gap := pkt.Timestamp.Sub(lastTimestamp); gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+		lastTimestamp = pkt.Timestamp
+
+		stream, source, ok := findStreamBySocket(pkt.DstIP, pkt.DstPort)
+		if !ok {
+			log.Printf("Replay: no configured stream for %s:%d, skipping", pkt.DstIP, pkt.DstPort)
+			skipped++
+			continue
+		}
+
+		header, err := parseStreamHeader(pkt.Payload)
+		if err != nil {
+			log.Printf("Replay: error parsing header: %v", err)
+			skipped++
+			continue
+		}
+
+		body := make([]byte, len(pkt.Payload)-8)
+		if len(pkt.Payload) > 8 {
+			copy(body, pkt.Payload[8:])
+		}
+
+		pipelineGapRecovery(stream).Observe(header, body, source)
+		delivered++
+	}
+
+	log.Printf("Replay: finished, %d packets delivered, %d skipped", delivered, skipped)
+	return nil
+}